@@ -0,0 +1,97 @@
+package discord
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+//go:generate swag init --parseDependency=false -g admin.go -d . -o ../docs --outputTypes json,yaml
+
+// ErrorResp 管理接口错误返回
+type ErrorResp struct {
+	Message string `json:"message" swaggertype:"string" description:"错误信息"`
+}
+
+// WithAdminToken 管理接口的访问令牌, 为空时管理接口不可用
+func WithAdminToken(token string) WithConfig {
+	return func(db *DiscordBot) {
+		db.adminToken = token
+	}
+}
+
+// AdminHandler 返回管理接口, 请求需携带 Authorization: Bearer <ADMIN_TOKEN>
+//
+//	@title						gobot admin API
+//	@version					1.0
+//	@description				gobot 管理接口: 频道、线程、用户授权、Bot配置及用量管理
+//	@BasePath					/
+//	@securityDefinitions.apikey	AdminToken
+//	@in							header
+//	@name						Authorization
+func (b *DiscordBot) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	b.registerChannelRoutes(mux)
+	b.registerAuthRoutes(mux)
+	b.registerBotConfigRoutes(mux)
+	b.registerUsageRoutes(mux)
+	return b.adminAuth(mux)
+}
+
+// adminAuth 校验管理令牌并为请求附加请求id
+func (b *DiscordBot) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if b.adminToken == "" {
+			writeError(w, http.StatusServiceUnavailable, "admin api disabled")
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(b.adminToken)) != 1 {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		ctx := r.Context()
+		if id := r.Header.Get(RequestIdKey); id != "" {
+			ctx = ContextWithRequestId(ctx, id)
+		} else {
+			ctx = ensureRequestId(ctx)
+		}
+		w.Header().Set(RequestIdKey, RequestIdFromContext(ctx))
+		ctxLogger(ctx).Info("admin request", zap.String("method", r.Method), zap.String("path", r.URL.Path))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireSession 依赖discord session的接口在 Start 之前或 Shutdown 之后返回503
+func (b *DiscordBot) requireSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !b.sessionRunning() {
+			writeError(w, http.StatusServiceUnavailable, "discord session not running")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, ErrorResp{Message: message})
+}
+
+// decodeJSON 解析请求体, 失败时写入400并返回false
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
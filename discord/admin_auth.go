@@ -0,0 +1,228 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type AuthAddReq struct {
+	Token string `json:"token" swaggertype:"string" description:"用户token"`
+	AuthProfile
+}
+
+type AuthTestResp struct {
+	Ok       bool   `json:"ok" swaggertype:"boolean" description:"token是否可用"`
+	Username string `json:"username" swaggertype:"string" description:"token对应的用户名"`
+	Message  string `json:"message" swaggertype:"string" description:"失败原因"`
+}
+
+func (b *DiscordBot) registerAuthRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/auths", b.handleAuthList)
+	mux.HandleFunc("POST /admin/auths", b.handleAuthAdd)
+	mux.HandleFunc("DELETE /admin/auths/{id}", b.handleAuthRemove)
+	mux.HandleFunc("POST /admin/auths/{id}/disable", b.handleAuthDisable)
+	mux.HandleFunc("POST /admin/auths/{id}/enable", b.handleAuthEnable)
+	mux.HandleFunc("POST /admin/auths/{id}/test", b.handleAuthTest)
+	mux.HandleFunc("PUT /admin/auths/{id}/profile", b.handleAuthProfile)
+}
+
+func writeAuthError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrAuthNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrAuthExists):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// handleAuthList 列出所有用户授权(脱敏)
+//
+//	@Summary	用户授权列表
+//	@Tags		auth
+//	@Produce	json
+//	@Security	AdminToken
+//	@Success	200	{array}	AuthInfo
+//	@Router		/admin/auths [get]
+func (b *DiscordBot) handleAuthList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, b.auths.list())
+}
+
+// handleAuthAdd 新增用户授权
+//
+//	@Summary	新增用户授权
+//	@Tags		auth
+//	@Accept		json
+//	@Produce	json
+//	@Security	AdminToken
+//	@Param		req	body		AuthAddReq	true	"用户token及可选的代理与客户端标识"
+//	@Success	200	{object}	AuthInfo
+//	@Failure	400	{object}	ErrorResp
+//	@Failure	409	{object}	ErrorResp
+//	@Router		/admin/auths [post]
+func (b *DiscordBot) handleAuthAdd(w http.ResponseWriter, r *http.Request) {
+	var req AuthAddReq
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Token = strings.TrimSpace(req.Token)
+	if req.Token == "" || strings.Contains(req.Token, ",") {
+		writeError(w, http.StatusBadRequest, "invalid token")
+		return
+	}
+	if err := b.validateAuthProfile(req.AuthProfile); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	info, err := b.auths.add(req.Token, req.AuthProfile)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// handleAuthRemove 删除用户授权
+//
+//	@Summary	删除用户授权
+//	@Tags		auth
+//	@Produce	json
+//	@Security	AdminToken
+//	@Param		id	path		string	true	"授权ID"
+//	@Success	204
+//	@Failure	404	{object}	ErrorResp
+//	@Router		/admin/auths/{id} [delete]
+func (b *DiscordBot) handleAuthRemove(w http.ResponseWriter, r *http.Request) {
+	if err := b.auths.remove(r.PathValue("id")); err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAuthDisable 禁用用户授权
+//
+//	@Summary	禁用用户授权
+//	@Tags		auth
+//	@Produce	json
+//	@Security	AdminToken
+//	@Param		id	path		string	true	"授权ID"
+//	@Success	200	{object}	AuthInfo
+//	@Failure	404	{object}	ErrorResp
+//	@Router		/admin/auths/{id}/disable [post]
+func (b *DiscordBot) handleAuthDisable(w http.ResponseWriter, r *http.Request) {
+	info, err := b.auths.setDisabled(r.PathValue("id"), true)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// handleAuthEnable 启用用户授权
+//
+//	@Summary	启用用户授权
+//	@Tags		auth
+//	@Produce	json
+//	@Security	AdminToken
+//	@Param		id	path		string	true	"授权ID"
+//	@Success	200	{object}	AuthInfo
+//	@Failure	404	{object}	ErrorResp
+//	@Router		/admin/auths/{id}/enable [post]
+func (b *DiscordBot) handleAuthEnable(w http.ResponseWriter, r *http.Request) {
+	info, err := b.auths.setDisabled(r.PathValue("id"), false)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// handleAuthProfile 设置用户授权的代理与客户端标识
+//
+//	@Summary	设置用户授权的客户端配置
+//	@Tags		auth
+//	@Accept		json
+//	@Produce	json
+//	@Security	AdminToken
+//	@Param		id	path		string		true	"授权ID"
+//	@Param		req	body		AuthProfile	true	"代理与客户端标识, 为空的项使用全局配置"
+//	@Success	200	{object}	AuthInfo
+//	@Failure	400	{object}	ErrorResp
+//	@Failure	404	{object}	ErrorResp
+//	@Router		/admin/auths/{id}/profile [put]
+func (b *DiscordBot) handleAuthProfile(w http.ResponseWriter, r *http.Request) {
+	var profile AuthProfile
+	if !decodeJSON(w, r, &profile) {
+		return
+	}
+	if err := b.validateAuthProfile(profile); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	info, err := b.auths.setProfile(r.PathValue("id"), profile)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// validateAuthProfile 校验代理地址能否用于创建 http.Client
+func (b *DiscordBot) validateAuthProfile(profile AuthProfile) error {
+	if profile.Proxy == "" {
+		return nil
+	}
+	_, err := b.newAuthClient(profile.Proxy)
+	return err
+}
+
+// handleAuthTest 调用 /users/@me 验证用户授权是否可用
+//
+//	@Summary	测试用户授权
+//	@Tags		auth
+//	@Produce	json
+//	@Security	AdminToken
+//	@Param		id	path		string	true	"授权ID"
+//	@Success	200	{object}	AuthTestResp
+//	@Failure	404	{object}	ErrorResp
+//	@Router		/admin/auths/{id}/test [post]
+func (b *DiscordBot) handleAuthTest(w http.ResponseWriter, r *http.Request) {
+	_, token, err := b.auths.get(r.PathValue("id"))
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	username, err := b.TestAuthorization(r.Context(), token)
+	if err != nil {
+		b.auths.recordError(token, err, false)
+		writeJSON(w, http.StatusOK, AuthTestResp{Message: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, AuthTestResp{Ok: true, Username: username})
+}
+
+// TestAuthorization 使用用户token请求 /users/@me, 返回对应用户名
+func (b *DiscordBot) TestAuthorization(ctx context.Context, userAuth string) (string, error) {
+	resp, body, err := b.doUserRequest(ctx, userAuth, "GET /users/@me", "", func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", "https://discord.com/api/v9/users/@me", nil)
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("/api/v9/users/@me status %d: %s", resp.StatusCode, body)
+	}
+	var user struct {
+		Username string `json:"username"`
+	}
+	if err := json.Unmarshal(body, &user); err != nil {
+		return "", err
+	}
+	return user.Username, nil
+}
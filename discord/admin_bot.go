@@ -0,0 +1,142 @@
+package discord
+
+import (
+	"errors"
+	"net/http"
+)
+
+func (b *DiscordBot) registerBotConfigRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/bots", b.handleBotConfigList)
+	mux.HandleFunc("GET /admin/bots/{id}", b.handleBotConfigGet)
+	mux.HandleFunc("POST /admin/bots", b.handleBotConfigCreate)
+	mux.HandleFunc("PUT /admin/bots/{id}", b.handleBotConfigUpdate)
+	mux.HandleFunc("DELETE /admin/bots/{id}", b.handleBotConfigDelete)
+}
+
+func writeBotConfigError(w http.ResponseWriter, err error) {
+	var validationErr *BotConfigValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrBotConfigNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrBotConfigDuplicate):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// handleBotConfigList 列出所有 BotConfig
+//
+//	@Summary	Bot配置列表
+//	@Tags		bot
+//	@Produce	json
+//	@Security	AdminToken
+//	@Success	200	{array}	BotConfig
+//	@Router		/admin/bots [get]
+func (b *DiscordBot) handleBotConfigList(w http.ResponseWriter, r *http.Request) {
+	configs := b.BotConfigs()
+	if configs == nil {
+		configs = []BotConfig{}
+	}
+	writeJSON(w, http.StatusOK, configs)
+}
+
+// handleBotConfigGet 查询 BotConfig
+//
+//	@Summary	查询Bot配置
+//	@Tags		bot
+//	@Produce	json
+//	@Security	AdminToken
+//	@Param		id	path		string	true	"配置ID"
+//	@Success	200	{object}	BotConfig
+//	@Failure	404	{object}	ErrorResp
+//	@Router		/admin/bots/{id} [get]
+func (b *DiscordBot) handleBotConfigGet(w http.ResponseWriter, r *http.Request) {
+	config, err := b.botConfigs.get(r.PathValue("id"))
+	if err != nil {
+		writeBotConfigError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, config)
+}
+
+// handleBotConfigCreate 新增 BotConfig
+//
+//	@Summary	新增Bot配置
+//	@Tags		bot
+//	@Accept		json
+//	@Produce	json
+//	@Security	AdminToken
+//	@Param		req	body		BotConfig	true	"Bot配置, id 由服务端生成"
+//	@Success	200	{object}	BotConfig
+//	@Failure	400	{object}	ErrorResp
+//	@Failure	409	{object}	ErrorResp
+//	@Router		/admin/bots [post]
+func (b *DiscordBot) handleBotConfigCreate(w http.ResponseWriter, r *http.Request) {
+	var config BotConfig
+	if !decodeJSON(w, r, &config) {
+		return
+	}
+	if err := b.ValidateBotConfig(config); err != nil {
+		writeBotConfigError(w, err)
+		return
+	}
+	config, err := b.botConfigs.create(config)
+	if err != nil {
+		writeBotConfigError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, config)
+}
+
+// handleBotConfigUpdate 更新 BotConfig
+//
+//	@Summary	更新Bot配置
+//	@Tags		bot
+//	@Accept		json
+//	@Produce	json
+//	@Security	AdminToken
+//	@Param		id	path		string		true	"配置ID"
+//	@Param		req	body		BotConfig	true	"Bot配置"
+//	@Success	200	{object}	BotConfig
+//	@Failure	400	{object}	ErrorResp
+//	@Failure	404	{object}	ErrorResp
+//	@Failure	409	{object}	ErrorResp
+//	@Router		/admin/bots/{id} [put]
+func (b *DiscordBot) handleBotConfigUpdate(w http.ResponseWriter, r *http.Request) {
+	var config BotConfig
+	if !decodeJSON(w, r, &config) {
+		return
+	}
+	config.Id = r.PathValue("id")
+	if err := b.ValidateBotConfig(config); err != nil {
+		writeBotConfigError(w, err)
+		return
+	}
+	config, err := b.botConfigs.update(config)
+	if err != nil {
+		writeBotConfigError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, config)
+}
+
+// handleBotConfigDelete 删除 BotConfig
+//
+//	@Summary	删除Bot配置
+//	@Tags		bot
+//	@Produce	json
+//	@Security	AdminToken
+//	@Param		id	path	string	true	"配置ID"
+//	@Success	204
+//	@Failure	404	{object}	ErrorResp
+//	@Router		/admin/bots/{id} [delete]
+func (b *DiscordBot) handleBotConfigDelete(w http.ResponseWriter, r *http.Request) {
+	if err := b.botConfigs.delete(r.PathValue("id")); err != nil {
+		writeBotConfigError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
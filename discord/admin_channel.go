@@ -0,0 +1,225 @@
+package discord
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type TempChannelResp struct {
+	Id         string `json:"id" swaggertype:"string" description:"频道ID"`
+	Name       string `json:"name" swaggertype:"string" description:"频道名称"`
+	CreatedAt  int64  `json:"createdAt" swaggertype:"number" description:"创建时间[unix秒]"`
+	AgeSeconds int64  `json:"ageSeconds" swaggertype:"number" description:"已存在时长[秒]"`
+}
+
+type CleanupResp struct {
+	Deleted []string `json:"deleted" swaggertype:"array,string" description:"已删除的频道ID"`
+	Failed  []string `json:"failed" swaggertype:"array,string" description:"删除失败的频道ID"`
+}
+
+func (b *DiscordBot) registerChannelRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /admin/channels", b.requireSession(b.handleChannelCreate))
+	mux.HandleFunc("DELETE /admin/channels/{id}", b.requireSession(b.handleChannelDel))
+	mux.HandleFunc("GET /admin/channels/temp", b.requireSession(b.handleTempChannelList))
+	mux.HandleFunc("POST /admin/channels/temp/cleanup", b.requireSession(b.handleTempChannelCleanup))
+	mux.HandleFunc("POST /admin/threads", b.requireSession(b.handleThreadStart))
+}
+
+// isUnknownChannel discord 返回404或 Unknown Channel 错误
+func isUnknownChannel(err error) bool {
+	var restErr *discordgo.RESTError
+	if !errors.As(err, &restErr) {
+		return false
+	}
+	return (restErr.Response != nil && restErr.Response.StatusCode == http.StatusNotFound) ||
+		(restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeUnknownChannel)
+}
+
+// tempChannels 返回服务器内所有可清理的 cdp-chat- 临时频道
+func (b *DiscordBot) tempChannels() ([]TempChannelResp, error) {
+	channels, err := b.getSession().GuildChannels(b.guildID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var result []TempChannelResp
+	for _, channel := range channels {
+		if !strings.HasPrefix(channel.Name, "cdp-chat-") || b.isReservedChannel(channel.ID) {
+			continue
+		}
+		createdAt, err := discordgo.SnowflakeTimestamp(channel.ID)
+		if err != nil {
+			continue
+		}
+		result = append(result, TempChannelResp{
+			Id:         channel.ID,
+			Name:       channel.Name,
+			CreatedAt:  createdAt.Unix(),
+			AgeSeconds: int64(now.Sub(createdAt).Seconds()),
+		})
+	}
+	return result, nil
+}
+
+// handleChannelCreate 创建频道或频道分类
+//
+//	@Summary	创建频道
+//	@Tags		channel
+//	@Accept		json
+//	@Produce	json
+//	@Security	AdminToken
+//	@Param		req	body		ChannelReq	true	"频道参数"
+//	@Success	200	{object}	ChannelResp
+//	@Failure	400	{object}	ErrorResp
+//	@Failure	500	{object}	ErrorResp
+//	@Failure	503	{object}	ErrorResp
+//	@Router		/admin/channels [post]
+func (b *DiscordBot) handleChannelCreate(w http.ResponseWriter, r *http.Request) {
+	var req ChannelReq
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	var id string
+	var err error
+	if req.ParentId == "" {
+		id, err = b.ChannelCreate(b.guildID, req.Name, req.Type)
+	} else {
+		id, err = b.ChannelCreateComplex(b.guildID, req.ParentId, req.Name, req.Type)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ChannelResp{Id: id, Name: req.Name})
+}
+
+// handleChannelDel 删除频道或频道分类
+//
+//	@Summary	删除频道
+//	@Tags		channel
+//	@Produce	json
+//	@Security	AdminToken
+//	@Param		id	path		string	true	"频道ID"
+//	@Success	200	{object}	ChannelResp
+//	@Failure	404	{object}	ErrorResp
+//	@Failure	500	{object}	ErrorResp
+//	@Failure	503	{object}	ErrorResp
+//	@Router		/admin/channels/{id} [delete]
+func (b *DiscordBot) handleChannelDel(w http.ResponseWriter, r *http.Request) {
+	id, err := b.ChannelDel(r.PathValue("id"))
+	if isUnknownChannel(err) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ChannelResp{Id: id})
+}
+
+// handleTempChannelList 列出 cdp-chat- 临时频道及其存在时长
+//
+//	@Summary	临时频道列表
+//	@Tags		channel
+//	@Produce	json
+//	@Security	AdminToken
+//	@Success	200	{array}		TempChannelResp
+//	@Failure	500	{object}	ErrorResp
+//	@Failure	503	{object}	ErrorResp
+//	@Router		/admin/channels/temp [get]
+func (b *DiscordBot) handleTempChannelList(w http.ResponseWriter, r *http.Request) {
+	channels, err := b.tempChannels()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if channels == nil {
+		channels = []TempChannelResp{}
+	}
+	writeJSON(w, http.StatusOK, channels)
+}
+
+// handleTempChannelCleanup 删除存在时长超过 olderThan 的临时频道
+//
+//	@Summary	清理临时频道
+//	@Tags		channel
+//	@Produce	json
+//	@Security	AdminToken
+//	@Param		olderThan	query		int	false	"仅删除存在超过该秒数的频道, 默认为请求超时时间与建议保留时长中的较大者"
+//	@Success	200			{object}	CleanupResp
+//	@Failure	400			{object}	ErrorResp
+//	@Failure	500			{object}	ErrorResp
+//	@Failure	503			{object}	ErrorResp
+//	@Router		/admin/channels/temp/cleanup [post]
+func (b *DiscordBot) handleTempChannelCleanup(w http.ResponseWriter, r *http.Request) {
+	// 默认跳过可能仍在对话中或仍可通过 ClickSuggestion 继续对话的频道
+	olderThan := max(RequestOutTimeDuration, SuggestionChannelTTL)
+	if v := r.URL.Query().Get("olderThan"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 0 {
+			writeError(w, http.StatusBadRequest, "invalid olderThan")
+			return
+		}
+		olderThan = time.Duration(seconds) * time.Second
+	}
+
+	channels, err := b.tempChannels()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	resp := CleanupResp{Deleted: []string{}, Failed: []string{}}
+	for _, channel := range channels {
+		if time.Duration(channel.AgeSeconds)*time.Second < olderThan {
+			continue
+		}
+		if _, err := b.ChannelDel(channel.Id); err != nil {
+			resp.Failed = append(resp.Failed, channel.Id)
+			continue
+		}
+		b.CancelChannelDeleteTimer(channel.Id)
+		resp.Deleted = append(resp.Deleted, channel.Id)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleThreadStart 在指定频道下创建线程
+//
+//	@Summary	创建线程
+//	@Tags		thread
+//	@Accept		json
+//	@Produce	json
+//	@Security	AdminToken
+//	@Param		req	body		ThreadReq	true	"线程参数"
+//	@Success	200	{object}	ThreadResp
+//	@Failure	400	{object}	ErrorResp
+//	@Failure	500	{object}	ErrorResp
+//	@Failure	503	{object}	ErrorResp
+//	@Router		/admin/threads [post]
+func (b *DiscordBot) handleThreadStart(w http.ResponseWriter, r *http.Request) {
+	var req ThreadReq
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.ChannelId == "" || req.Name == "" {
+		writeError(w, http.StatusBadRequest, "channelId and name are required")
+		return
+	}
+	id, err := b.ThreadStart(req.ChannelId, req.Name, req.ArchiveDuration)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ThreadResp{Id: id, Name: req.Name})
+}
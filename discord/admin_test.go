@@ -0,0 +1,55 @@
+package discord
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestAdminChannelRoutesRequireSession(t *testing.T) {
+	b := NewDiscordBot("", WithAdminToken("secret"))
+	handler := b.AdminHandler()
+
+	for _, route := range [][2]string{
+		{http.MethodPost, "/admin/channels"},
+		{http.MethodDelete, "/admin/channels/123"},
+		{http.MethodGet, "/admin/channels/temp"},
+		{http.MethodPost, "/admin/channels/temp/cleanup"},
+		{http.MethodPost, "/admin/threads"},
+	} {
+		req := httptest.NewRequest(route[0], route[1], nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s %s: status = %d, want 503", route[0], route[1], rec.Code)
+		}
+	}
+
+	// 不依赖session的接口不受影响
+	req := httptest.NewRequest(http.MethodGet, "/admin/bots", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code == http.StatusServiceUnavailable {
+		t.Errorf("GET /admin/bots: status = 503 before Start")
+	}
+}
+
+func TestIsUnknownChannel(t *testing.T) {
+	notFound := &discordgo.RESTError{Response: &http.Response{StatusCode: http.StatusNotFound}}
+	unknown := &discordgo.RESTError{
+		Response: &http.Response{StatusCode: http.StatusBadRequest},
+		Message:  &discordgo.APIErrorMessage{Code: discordgo.ErrCodeUnknownChannel},
+	}
+	forbidden := &discordgo.RESTError{Response: &http.Response{StatusCode: http.StatusForbidden}}
+	if !isUnknownChannel(notFound) || !isUnknownChannel(fmt.Errorf("delete: %w", unknown)) {
+		t.Fatal("404 and Unknown Channel errors should map to not found")
+	}
+	if isUnknownChannel(forbidden) || isUnknownChannel(nil) {
+		t.Fatal("other errors should not map to not found")
+	}
+}
@@ -0,0 +1,168 @@
+package discord
+
+import (
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (b *DiscordBot) registerUsageRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/usage", b.handleUsageReport)
+	mux.HandleFunc("GET /admin/usage/monthly", b.handleUsageMonthly)
+	mux.HandleFunc("GET /admin/quotas", b.handleQuotaList)
+	mux.HandleFunc("PUT /admin/quotas", b.handleQuotaSet)
+	mux.HandleFunc("DELETE /admin/quotas/{id}", b.handleQuotaDelete)
+}
+
+// validDate 校验日期参数格式, 空值视为合法
+func validDate(layout, value string) bool {
+	if value == "" {
+		return true
+	}
+	_, err := time.Parse(layout, value)
+	return err == nil
+}
+
+// writeUsage 按 format 参数以json或csv格式返回用量
+func writeUsage(w http.ResponseWriter, r *http.Request, records []UsageRecord, filename string) {
+	if records == nil {
+		records = []UsageRecord{}
+	}
+	if r.URL.Query().Get("format") != "csv" {
+		writeJSON(w, http.StatusOK, records)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "secretId", "masked", "requests", "promptTokens", "completionTokens", "totalTokens", "images"})
+	for _, record := range records {
+		cw.Write([]string{
+			record.Date,
+			record.SecretId,
+			record.Masked,
+			strconv.Itoa(record.Requests),
+			strconv.Itoa(record.PromptTokens),
+			strconv.Itoa(record.CompletionTokens),
+			strconv.Itoa(record.TotalTokens()),
+			strconv.Itoa(record.Images),
+		})
+	}
+	cw.Flush()
+}
+
+// handleUsageReport 查询每日用量
+//
+//	@Summary	每日用量
+//	@Tags		usage
+//	@Produce	json,text/csv
+//	@Security	AdminToken
+//	@Param		from		query		string	false	"开始日期 2006-01-02"
+//	@Param		to			query		string	false	"结束日期 2006-01-02"
+//	@Param		secretId	query		string	false	"proxySecret ID"
+//	@Param		format		query		string	false	"json 或 csv"
+//	@Success	200			{array}		UsageRecord
+//	@Failure	400			{object}	ErrorResp
+//	@Router		/admin/usage [get]
+func (b *DiscordBot) handleUsageReport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to := query.Get("from"), query.Get("to")
+	if !validDate(usageDateLayout, from) || !validDate(usageDateLayout, to) {
+		writeError(w, http.StatusBadRequest, "invalid from or to")
+		return
+	}
+	writeUsage(w, r, b.usage.report(from, to, query.Get("secretId")), "usage")
+}
+
+// handleUsageMonthly 按月汇总每个 proxySecret 的用量, 用于结算
+//
+//	@Summary	月度用量
+//	@Tags		usage
+//	@Produce	json,text/csv
+//	@Security	AdminToken
+//	@Param		month	query		string	false	"月份 2006-01, 默认当月"
+//	@Param		format	query		string	false	"json 或 csv"
+//	@Success	200		{array}		UsageRecord
+//	@Failure	400		{object}	ErrorResp
+//	@Router		/admin/usage/monthly [get]
+func (b *DiscordBot) handleUsageMonthly(w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = time.Now().Format("2006-01")
+	}
+	if !validDate("2006-01", month) {
+		writeError(w, http.StatusBadRequest, "invalid month")
+		return
+	}
+	writeUsage(w, r, b.usage.monthly(month), "usage-"+month)
+}
+
+// handleQuotaList 列出所有配额
+//
+//	@Summary	配额列表
+//	@Tags		usage
+//	@Produce	json
+//	@Security	AdminToken
+//	@Success	200	{array}	UsageQuota
+//	@Router		/admin/quotas [get]
+func (b *DiscordBot) handleQuotaList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, b.usage.listQuotas())
+}
+
+// handleQuotaSet 设置 proxySecret 的配额
+//
+//	@Summary	设置配额
+//	@Tags		usage
+//	@Accept		json
+//	@Produce	json
+//	@Security	AdminToken
+//	@Param		req	body		UsageQuota	true	"配额, secret 必填"
+//	@Success	200	{object}	UsageQuota
+//	@Failure	400	{object}	ErrorResp
+//	@Failure	500	{object}	ErrorResp
+//	@Router		/admin/quotas [put]
+func (b *DiscordBot) handleQuotaSet(w http.ResponseWriter, r *http.Request) {
+	var quota UsageQuota
+	if !decodeJSON(w, r, &quota) {
+		return
+	}
+	if quota.Secret == "" {
+		writeError(w, http.StatusBadRequest, "secret is required")
+		return
+	}
+	if quota.DailyRequests < 0 || quota.DailyTokens < 0 || quota.DailyImages < 0 || quota.MonthlyTokens < 0 {
+		writeError(w, http.StatusBadRequest, "quota must not be negative")
+		return
+	}
+	quota, err := b.usage.setQuota(quota)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, quota)
+}
+
+// handleQuotaDelete 删除配额
+//
+//	@Summary	删除配额
+//	@Tags		usage
+//	@Produce	json
+//	@Security	AdminToken
+//	@Param		id	path	string	true	"proxySecret ID"
+//	@Success	204
+//	@Failure	404	{object}	ErrorResp
+//	@Router		/admin/quotas/{id} [delete]
+func (b *DiscordBot) handleQuotaDelete(w http.ResponseWriter, r *http.Request) {
+	if err := b.usage.deleteQuota(r.PathValue("id")); err != nil {
+		if errors.Is(err, ErrQuotaNotFound) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
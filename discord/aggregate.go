@@ -0,0 +1,119 @@
+package discord
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ReplyPartSeparator 多条回复消息合并时的分隔符
+var ReplyPartSeparator = "\n"
+
+// replyAggregator 合并bot在一次请求中发出的多条回复消息: 按消息ID排序拼接, 每条消息的编辑各自更新
+type replyAggregator struct {
+	ids   []string          // 按消息ID升序
+	parts map[string]string // 消息ID -> 最新内容
+	metas map[string]*replyMeta
+}
+
+// replyMeta 一条回复消息的嵌入、附件与建议按钮
+type replyMeta struct {
+	embeds      []*discordgo.MessageEmbed
+	attachments []*discordgo.MessageAttachment
+	suggestions []string
+}
+
+func newReplyAggregator() *replyAggregator {
+	return &replyAggregator{parts: make(map[string]string), metas: make(map[string]*replyMeta)}
+}
+
+// update 更新一条回复消息的内容, 返回合并后的内容
+func (a *replyAggregator) update(messageId, content string) string {
+	if _, ok := a.parts[messageId]; !ok {
+		i := sort.Search(len(a.ids), func(i int) bool { return !snowflakeLess(a.ids[i], messageId) })
+		a.ids = append(a.ids, "")
+		copy(a.ids[i+1:], a.ids[i:])
+		a.ids[i] = messageId
+	}
+	a.parts[messageId] = content
+	return a.content()
+}
+
+// observe 记录回复消息的嵌入、附件与建议按钮, 编辑事件中未携带的字段保留之前的值
+func (a *replyAggregator) observe(m *discordgo.Message) {
+	if m == nil {
+		return
+	}
+	meta, ok := a.metas[m.ID]
+	if !ok {
+		meta = &replyMeta{}
+		a.metas[m.ID] = meta
+	}
+	if m.Embeds != nil {
+		meta.embeds = m.Embeds
+	}
+	if m.Attachments != nil {
+		meta.attachments = m.Attachments
+	}
+	if m.Components != nil {
+		meta.suggestions = parseSuggestions(m.Components)
+	}
+}
+
+// result 按消息顺序汇总的回复
+func (a *replyAggregator) result(content string) *SendResult {
+	result := &SendResult{Content: content}
+	for _, id := range a.ids {
+		meta, ok := a.metas[id]
+		if !ok {
+			continue
+		}
+		result.Embeds = append(result.Embeds, meta.embeds...)
+		result.Attachments = append(result.Attachments, meta.attachments...)
+		result.Suggestions = append(result.Suggestions, meta.suggestions...)
+	}
+	return result
+}
+
+// content 按消息顺序合并的内容, 跳过空消息
+func (a *replyAggregator) content() string {
+	parts := make([]string, 0, len(a.ids))
+	for _, id := range a.ids {
+		if part := a.parts[id]; part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ReplyPartSeparator)
+}
+
+// deltaStream 将不断更新的完整内容转换为只追加的增量输出
+type deltaStream struct {
+	model    string // 当前使用的模型, 用于输出的每一块
+	emitted  string
+	diverged bool // 最近一次更新的内容不以已输出内容开头, 已输出的内容与回复不一致
+	send     func(delta string)
+}
+
+// update 输出 content 相对已输出内容新增的部分. 已输出的内容被修改(如较早的消息被编辑)时无法以追加方式表达,
+// 暂不输出并标记 diverged, 直到内容再次以已输出内容开头
+func (d *deltaStream) update(content string) {
+	if d == nil {
+		return
+	}
+	d.diverged = !strings.HasPrefix(content, d.emitted)
+	if d.diverged || len(content) == len(d.emitted) {
+		return
+	}
+	delta := content[len(d.emitted):]
+	d.emitted = content
+	d.send(delta)
+}
+
+// snowflakeLess 比较两个discord消息ID的先后
+func snowflakeLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
@@ -0,0 +1,39 @@
+package discord
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReplyAggregatorOrder(t *testing.T) {
+	a := newReplyAggregator()
+	var deltas []string
+	stream := &deltaStream{send: func(delta string) { deltas = append(deltas, delta) }}
+
+	stream.update(a.update("1000000000000000001", "first"))
+	stream.update(a.update("1000000000000000001", "first part"))
+	// 第二条消息ID更大, 排在后面
+	stream.update(a.update("1000000000000000009", "second"))
+	// 乱序到达的较早消息插入到前面, 已输出的内容无法追加表达
+	combined := a.update("999999999999999999", "zero")
+	stream.update(combined)
+	if combined != "zero\nfirst part\nsecond" {
+		t.Fatalf("unexpected combined content: %q", combined)
+	}
+	if got := strings.Join(deltas, ""); got != "first part\nsecond" {
+		t.Fatalf("deltas should only ever append: %q", deltas)
+	}
+	if !stream.diverged {
+		t.Fatal("stream should report that the emitted content differs from the reply")
+	}
+	// 内容再次以已输出内容开头时恢复输出
+	stream.update("first part\nsecond\nthird")
+	if stream.diverged || strings.Join(deltas, "") != "first part\nsecond\nthird" {
+		t.Fatalf("stream should resume once content extends the emitted prefix: %q", deltas)
+	}
+
+	// 编辑一条消息只更新该部分
+	if combined := a.update("1000000000000000009", "second edited"); combined != "zero\nfirst part\nsecond edited" {
+		t.Fatalf("edit not applied to its own part: %q", combined)
+	}
+}
@@ -0,0 +1,61 @@
+package discord
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ModelAlias 模型别名: 请求的模型名匹配 Pattern(glob) 时, 依次尝试 Targets 中的模型,
+// 每个目标模型对应 BotConfig.Model 中包含该模型的一组bot
+type ModelAlias struct {
+	Pattern string   `json:"pattern"`
+	Targets []string `json:"targets"`
+}
+
+// WithModelAliases 设置模型别名表, 按顺序匹配, 首个命中的规则生效
+func WithModelAliases(aliases []ModelAlias) WithConfig {
+	return func(db *DiscordBot) {
+		db.modelAliases = aliases
+	}
+}
+
+// ParseModelAliases 解析形如 "gpt-4*=coze-4|coze-3.5;gpt-3.5*=coze-3.5" 的别名配置
+func ParseModelAliases(s string) ([]ModelAlias, error) {
+	var aliases []ModelAlias
+	for _, rule := range strings.Split(s, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		pattern, targets, ok := strings.Cut(rule, "=")
+		pattern = strings.TrimSpace(pattern)
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("invalid model alias rule %q", rule)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid model alias pattern %q: %w", pattern, err)
+		}
+		alias := ModelAlias{Pattern: pattern}
+		for _, target := range strings.Split(targets, "|") {
+			if target = strings.TrimSpace(target); target != "" {
+				alias.Targets = append(alias.Targets, target)
+			}
+		}
+		if len(alias.Targets) == 0 {
+			return nil, fmt.Errorf("model alias rule %q has no target", rule)
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, nil
+}
+
+// resolveModelChain 返回请求模型对应的候选模型链, 无别名命中时仅包含请求模型本身
+func resolveModelChain(aliases []ModelAlias, model string) []string {
+	for _, alias := range aliases {
+		if ok, _ := path.Match(alias.Pattern, model); ok {
+			return alias.Targets
+		}
+	}
+	return []string{model}
+}
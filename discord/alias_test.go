@@ -0,0 +1,43 @@
+package discord
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseModelAliases(t *testing.T) {
+	aliases, err := ParseModelAliases("gpt-4*=coze-4|coze-3.5; gpt-3.5*=coze-3.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ModelAlias{
+		{Pattern: "gpt-4*", Targets: []string{"coze-4", "coze-3.5"}},
+		{Pattern: "gpt-3.5*", Targets: []string{"coze-3.5"}},
+	}
+	if !reflect.DeepEqual(aliases, want) {
+		t.Errorf("got %+v, want %+v", aliases, want)
+	}
+
+	for _, bad := range []string{"gpt-4*", "=coze-4", "gpt-4*=", "[=coze"} {
+		if _, err := ParseModelAliases(bad); err == nil {
+			t.Errorf("ParseModelAliases(%q) expected error", bad)
+		}
+	}
+}
+
+func TestResolveModelChain(t *testing.T) {
+	aliases := []ModelAlias{
+		{Pattern: "gpt-4o*", Targets: []string{"coze-4o"}},
+		{Pattern: "gpt-4*", Targets: []string{"coze-4", "coze-3.5"}},
+	}
+	cases := map[string][]string{
+		"gpt-4o-2024-05-13": {"coze-4o"},
+		"gpt-4-turbo":       {"coze-4", "coze-3.5"},
+		"dall-e-3":          {"dall-e-3"},
+	}
+	for model, want := range cases {
+		if got := resolveModelChain(aliases, model); !reflect.DeepEqual(got, want) {
+			t.Errorf("resolveModelChain(%q) = %v, want %v", model, got, want)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package discord
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrAuthNotFound = errors.New("user authorization not found")
+	ErrAuthExists   = errors.New("user authorization already exists")
+)
+
+// AuthInfo 用户授权的脱敏状态
+type AuthInfo struct {
+	Id          string    `json:"id" swaggertype:"string" description:"授权ID(token摘要)"`
+	Masked      string    `json:"masked" swaggertype:"string" description:"脱敏后的token"`
+	Disabled    bool      `json:"disabled" swaggertype:"boolean" description:"是否被手动禁用"`
+	Suspended   bool      `json:"suspended" swaggertype:"boolean" description:"是否因失效或达到每日上限被暂停,每日9点恢复"`
+	LastError   string    `json:"lastError" swaggertype:"string" description:"最近一次错误"`
+	LastErrorAt time.Time `json:"lastErrorAt" swaggertype:"string" description:"最近一次错误时间"`
+	LastUsedAt  time.Time `json:"lastUsedAt" swaggertype:"string" description:"最近一次使用时间"`
+	Inflight    int       `json:"inflight" swaggertype:"number" description:"正在使用该授权发送的请求数"`
+	Proxy       string    `json:"proxy" swaggertype:"string" description:"该授权使用的代理(隐藏密码)"`
+	UserAgent   string    `json:"userAgent" swaggertype:"string" description:"该授权使用的User-Agent"`
+}
+
+// AuthProfile 用户授权的客户端配置: 每个账号使用独立的代理出口与客户端标识, 为空的项使用全局配置
+type AuthProfile struct {
+	Proxy     string `json:"proxy" swaggertype:"string" description:"代理地址, 支持 http 与 socks5"`
+	UserAgent string `json:"userAgent" swaggertype:"string" description:"User-Agent"`
+}
+
+type authEntry struct {
+	token   string
+	profile AuthProfile
+	client  *http.Client // 按 profile.Proxy 创建, profile 变更时重建
+	AuthInfo
+}
+
+// authStoreEntry 持久化格式
+type authStoreEntry struct {
+	Token    string `json:"token"`
+	Disabled bool   `json:"disabled"`
+	AuthProfile
+}
+
+// authPool 并发安全的用户授权池, 支持运行时增删、禁用以及持久化
+type authPool struct {
+	mu        sync.RWMutex
+	entries   []*authEntry
+	storePath string
+	profiles  map[string]AuthProfile // WithAuthProfiles 指定的配置, 优先于持久化文件
+}
+
+// AuthId 根据token生成稳定且不泄露token的ID
+func AuthId(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func newAuthPool(auth string) *authPool {
+	p := &authPool{}
+	for _, token := range strings.Split(auth, ",") {
+		token = strings.TrimSpace(token)
+		if token != "" && p.find(token) == nil {
+			p.entries = append(p.entries, newAuthEntry(token))
+		}
+	}
+	return p
+}
+
+func newAuthEntry(token string) *authEntry {
+	return &authEntry{
+		token:    token,
+		AuthInfo: AuthInfo{Id: AuthId(token), Masked: MaskSecret(token)},
+	}
+}
+
+func (p *authPool) find(token string) *authEntry {
+	for _, e := range p.entries {
+		if e.token == token {
+			return e
+		}
+	}
+	return nil
+}
+
+func (p *authPool) findId(id string) *authEntry {
+	for _, e := range p.entries {
+		if e.Id == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// load 从存储文件加载授权列表. 文件存在时以文件中的授权为准, 通过管理接口删除的token重启后不会因
+// USER_AUTHORIZATION 而恢复, 代理与User-Agent以 WithAuthProfiles 中非空的项为准. 文件不存在时写入当前列表
+func (p *authPool) load(path string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.storePath = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p.saveLocked()
+	}
+	if err != nil {
+		return err
+	}
+	var stored []authStoreEntry
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("parse auth store %s: %w", path, err)
+	}
+	entries := make([]*authEntry, 0, len(stored))
+	for _, s := range stored {
+		if s.Token == "" || containsAuth(entries, s.Token) {
+			continue
+		}
+		e := newAuthEntry(s.Token)
+		e.Disabled = s.Disabled
+		e.setProfile(mergeProfile(s.AuthProfile, p.profiles[s.Token]))
+		entries = append(entries, e)
+	}
+	p.entries = entries
+	return nil
+}
+
+func containsAuth(entries []*authEntry, token string) bool {
+	for _, e := range entries {
+		if e.token == token {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeProfile 以 override 中非空的项覆盖 base
+func mergeProfile(base, override AuthProfile) AuthProfile {
+	if override.Proxy != "" {
+		base.Proxy = override.Proxy
+	}
+	if override.UserAgent != "" {
+		base.UserAgent = override.UserAgent
+	}
+	return base
+}
+
+func (p *authPool) saveLocked() error {
+	if p.storePath == "" {
+		return nil
+	}
+	stored := make([]authStoreEntry, 0, len(p.entries))
+	for _, e := range p.entries {
+		stored = append(stored, authStoreEntry{Token: e.token, Disabled: e.Disabled, AuthProfile: e.profile})
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := p.storePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.storePath)
+}
+
+// count 返回授权总数(含禁用与暂停)
+func (p *authPool) count() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.entries)
+}
+
+// usable 返回当前可用的token
+func (p *authPool) usable() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var tokens []string
+	for _, e := range p.entries {
+		if !e.Disabled && !e.Suspended {
+			tokens = append(tokens, e.token)
+		}
+	}
+	return tokens
+}
+
+// acquire 在可用token中选择正在发送数最少的一个(相同时随机), 使用完毕后需调用 release
+func (p *authPool) acquire() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var candidates []*authEntry
+	for _, e := range p.entries {
+		if e.Disabled || e.Suspended {
+			continue
+		}
+		if len(candidates) > 0 && e.Inflight < candidates[0].Inflight {
+			candidates = candidates[:0]
+		}
+		if len(candidates) == 0 || e.Inflight == candidates[0].Inflight {
+			candidates = append(candidates, e)
+		}
+	}
+	e, err := RandomElement(candidates)
+	if err != nil {
+		return "", fmt.Errorf("no available user authorization: %w", err)
+	}
+	e.Inflight++
+	e.LastUsedAt = time.Now()
+	return e.token, nil
+}
+
+func (p *authPool) release(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e := p.find(token); e != nil && e.Inflight > 0 {
+		e.Inflight--
+	}
+}
+
+// recordError 记录token最近一次错误, suspend 为true时暂停使用直到每日重置
+func (p *authPool) recordError(token string, err error, suspend bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e := p.find(token)
+	if e == nil {
+		return
+	}
+	e.LastError = err.Error()
+	e.LastErrorAt = time.Now()
+	if suspend {
+		e.Suspended = true
+	}
+}
+
+// resetSuspended 恢复所有被暂停的token
+func (p *authPool) resetSuspended() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, e := range p.entries {
+		e.Suspended = false
+	}
+}
+
+func (p *authPool) list() []AuthInfo {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	infos := make([]AuthInfo, 0, len(p.entries))
+	for _, e := range p.entries {
+		infos = append(infos, e.AuthInfo)
+	}
+	return infos
+}
+
+func (p *authPool) get(id string) (AuthInfo, string, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	e := p.findId(id)
+	if e == nil {
+		return AuthInfo{}, "", ErrAuthNotFound
+	}
+	return e.AuthInfo, e.token, nil
+}
+
+func (p *authPool) add(token string, profile AuthProfile) (AuthInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.find(token) != nil {
+		return AuthInfo{}, ErrAuthExists
+	}
+	e := newAuthEntry(token)
+	e.setProfile(profile)
+	p.entries = append(p.entries, e)
+	return e.AuthInfo, p.saveLocked()
+}
+
+func (p *authPool) remove(id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, e := range p.entries {
+		if e.Id == id {
+			p.entries = append(p.entries[:i], p.entries[i+1:]...)
+			return p.saveLocked()
+		}
+	}
+	return ErrAuthNotFound
+}
+
+func (p *authPool) setDisabled(id string, disabled bool) (AuthInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e := p.findId(id)
+	if e == nil {
+		return AuthInfo{}, ErrAuthNotFound
+	}
+	e.Disabled = disabled
+	return e.AuthInfo, p.saveLocked()
+}
+
+func (e *authEntry) setProfile(profile AuthProfile) {
+	e.profile = profile
+	e.client = nil
+	e.UserAgent = profile.UserAgent
+	e.Proxy = ""
+	if proxyURL, err := url.Parse(profile.Proxy); err == nil && profile.Proxy != "" {
+		e.Proxy = proxyURL.Redacted()
+	}
+}
+
+func (p *authPool) setProfile(id string, profile AuthProfile) (AuthInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e := p.findId(id)
+	if e == nil {
+		return AuthInfo{}, ErrAuthNotFound
+	}
+	e.setProfile(profile)
+	return e.AuthInfo, p.saveLocked()
+}
+
+// setProfiles 按token设置客户端配置, 不存在的token忽略
+func (p *authPool) setProfiles(profiles map[string]AuthProfile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.profiles = profiles
+	for token, profile := range profiles {
+		if e := p.find(token); e != nil {
+			e.setProfile(profile)
+		}
+	}
+}
+
+// client 返回token对应的客户端配置与 http.Client, 未配置代理时返回 nil client 表示使用共用client.
+// client 按token缓存, build 仅在首次使用或配置变更后调用
+func (p *authPool) client(token string, build func(proxy string) (*http.Client, error)) (AuthProfile, *http.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e := p.find(token)
+	if e == nil {
+		return AuthProfile{}, nil, nil
+	}
+	if e.profile.Proxy == "" {
+		return e.profile, nil, nil
+	}
+	if e.client == nil {
+		client, err := build(e.profile.Proxy)
+		if err != nil {
+			return e.profile, nil, fmt.Errorf("proxy of user authorization %s: %w", e.Id, err)
+		}
+		e.client = client
+	}
+	return e.profile, e.client, nil
+}
+
+// WithAuthProfiles 按用户token指定代理与客户端标识, 优先于持久化文件中保存的配置
+func WithAuthProfiles(profiles map[string]AuthProfile) WithConfig {
+	return func(db *DiscordBot) {
+		db.auths.setProfiles(profiles)
+	}
+}
+
+// WithAuthStorePath 用户授权持久化文件, 文件存在时以其中的授权为准, 忽略 USER_AUTHORIZATION;
+// 文件不存在时以 USER_AUTHORIZATION 初始化
+func WithAuthStorePath(path string) WithConfig {
+	return func(db *DiscordBot) {
+		db.authStorePath = path
+	}
+}
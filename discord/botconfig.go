@@ -0,0 +1,230 @@
+package discord
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+)
+
+var (
+	ErrBotConfigNotFound  = errors.New("bot config not found")
+	ErrBotConfigDuplicate = errors.New("bot config with the same botId and channelId already exists")
+)
+
+// BotConfigValidationError BotConfig 校验失败
+type BotConfigValidationError struct {
+	Problems []string
+}
+
+func (e *BotConfigValidationError) Error() string {
+	return fmt.Sprintf("invalid bot config: %v", e.Problems)
+}
+
+// botConfigStore 并发安全的 BotConfig 存储, 可持久化到json文件
+type botConfigStore struct {
+	mu        sync.RWMutex
+	configs   []BotConfig
+	storePath string
+}
+
+// WithBotConfigStorePath BotConfig 持久化文件, 文件存在时以其内容替代 BotConfigList
+func WithBotConfigStorePath(path string) WithConfig {
+	return func(db *DiscordBot) {
+		db.botConfigStorePath = path
+	}
+}
+
+// load 使用 BotConfigList 初始化, 设置了存储文件且文件存在时以文件内容为准
+func (s *botConfigStore) load(seed []BotConfig, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.storePath = path
+
+	configs := seed
+	if path != "" {
+		data, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			configs = nil
+			if err := json.Unmarshal(data, &configs); err != nil {
+				return fmt.Errorf("parse bot config store %s: %w", path, err)
+			}
+		case !errors.Is(err, os.ErrNotExist):
+			return err
+		}
+	}
+
+	s.configs = nil
+	for _, config := range FilterUniqueBotChannel(configs) {
+		if config.Id == "" {
+			id, err := NextID()
+			if err != nil {
+				return err
+			}
+			config.Id = id
+		}
+		s.configs = append(s.configs, config)
+	}
+	return s.saveLocked()
+}
+
+func (s *botConfigStore) saveLocked() error {
+	if s.storePath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.configs, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.storePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.storePath)
+}
+
+// snapshot 返回当前配置的副本
+func (s *botConfigStore) snapshot() []BotConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]BotConfig(nil), s.configs...)
+}
+
+func (s *botConfigStore) get(id string) (BotConfig, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, config := range s.configs {
+		if config.Id == id {
+			return config, nil
+		}
+	}
+	return BotConfig{}, ErrBotConfigNotFound
+}
+
+// duplicateLocked 检查是否存在相同 BotId+ChannelId 的其它配置, 与 FilterUniqueBotChannel 的去重规则一致
+func (s *botConfigStore) duplicateLocked(config BotConfig) bool {
+	for _, c := range s.configs {
+		if c.Id != config.Id && c.BotId == config.BotId && c.ChannelId == config.ChannelId {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *botConfigStore) create(config BotConfig) (BotConfig, error) {
+	id, err := NextID()
+	if err != nil {
+		return BotConfig{}, err
+	}
+	config.Id = id
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.duplicateLocked(config) {
+		return BotConfig{}, ErrBotConfigDuplicate
+	}
+	s.configs = append(s.configs, config)
+	return config, s.saveLocked()
+}
+
+func (s *botConfigStore) update(config BotConfig) (BotConfig, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, c := range s.configs {
+		if c.Id != config.Id {
+			continue
+		}
+		if s.duplicateLocked(config) {
+			return BotConfig{}, ErrBotConfigDuplicate
+		}
+		s.configs[i] = config
+		return config, s.saveLocked()
+	}
+	return BotConfig{}, ErrBotConfigNotFound
+}
+
+func (s *botConfigStore) delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, c := range s.configs {
+		if c.Id == id {
+			s.configs = append(s.configs[:i], s.configs[i+1:]...)
+			return s.saveLocked()
+		}
+	}
+	return ErrBotConfigNotFound
+}
+
+// BotConfigs 返回当前生效的 BotConfig
+func (b *DiscordBot) BotConfigs() []BotConfig {
+	return b.botConfigs.snapshot()
+}
+
+// SelectBotConfig 按 proxySecret、model 与 channelId 选择 BotConfig, 多个候选时按 Weight 加权随机.
+// 未配置任何 BotConfig 时使用默认的 COZE_BOT_ID; 有配置但无匹配时返回 *ModelNotFoundError
+func (b *DiscordBot) SelectBotConfig(secret, model string, channelId *string) (BotConfig, error) {
+	configs := b.BotConfigs()
+	if len(configs) == 0 {
+		return BotConfig{BotId: b.botID}, nil
+	}
+	candidates := FilterConfigs(configs, secret, model, channelId)
+	if len(candidates) == 0 {
+		return BotConfig{}, &ModelNotFoundError{
+			ErrCode: http.StatusNotFound,
+			Message: fmt.Sprintf("The model `%s` does not exist or you do not have access to it.", model),
+		}
+	}
+	return weightedRandom(candidates), nil
+}
+
+func weightedRandom(configs []BotConfig) BotConfig {
+	total := 0
+	for _, config := range configs {
+		total += max(config.Weight, 1)
+	}
+	n := rand.Intn(total)
+	for _, config := range configs {
+		n -= max(config.Weight, 1)
+		if n < 0 {
+			return config
+		}
+	}
+	return configs[len(configs)-1]
+}
+
+// ValidateBotConfig 校验 BotConfig, 设置了 ChannelId 时要求频道存在于当前服务器
+func (b *DiscordBot) ValidateBotConfig(config BotConfig) error {
+	var problems []string
+	session := b.getSession()
+	if config.BotId == "" {
+		problems = append(problems, "botId is required")
+	} else if session != nil && session.State.User != nil && session.State.User.ID == config.BotId {
+		problems = append(problems, "botId must not be the bot of BOT_TOKEN")
+	}
+	if len(FilterSlice(config.Model, "")) == 0 {
+		problems = append(problems, "model is required")
+	}
+	if config.Weight < 0 {
+		problems = append(problems, "weight must not be negative")
+	}
+	if c := config.Completion; c != nil && (c.TypingMs < 0 || c.TimeoutSec < 0) {
+		problems = append(problems, "completion typingMs and timeoutSec must not be negative")
+	}
+	if config.ChannelId != "" {
+		if session == nil {
+			problems = append(problems, "discord session not initialized, cannot verify channelId")
+		} else if channel, err := session.Channel(config.ChannelId); err != nil {
+			problems = append(problems, fmt.Sprintf("channel %s not found: %s", config.ChannelId, err))
+		} else if channel.GuildID != b.guildID {
+			problems = append(problems, fmt.Sprintf("channel %s does not belong to guild %s", config.ChannelId, b.guildID))
+		}
+	}
+	if len(problems) > 0 {
+		return &BotConfigValidationError{Problems: problems}
+	}
+	return nil
+}
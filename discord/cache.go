@@ -0,0 +1,219 @@
+package discord
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wwqdrh/gobot/types"
+	"go.uber.org/zap"
+)
+
+// CacheBypassHeader 请求头, 值为 1/true 时跳过响应缓存; Cache-Control: no-cache 同样生效
+const CacheBypassHeader = "X-Cache-Bypass"
+
+// CacheEntry 缓存的回复
+type CacheEntry struct {
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CacheBackend 响应缓存的持久化后端, 内存LRU未命中时查询, 写入时同时写入
+type CacheBackend interface {
+	Get(ctx context.Context, key string) (CacheEntry, bool, error)
+	Set(ctx context.Context, key string, entry CacheEntry) error
+	Delete(ctx context.Context, key string) error
+}
+
+type cacheItem struct {
+	key   string
+	entry CacheEntry
+}
+
+// responseCache 按 (model, 规范化后的messages) 缓存回复, 内存LRU + 可选持久化后端
+type responseCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+	backend    CacheBackend
+}
+
+// WithResponseCache 开启响应缓存, ttl 为缓存有效期, maxEntries 为内存中最多缓存的条数, backend 为nil时仅缓存在内存中
+func WithResponseCache(ttl time.Duration, maxEntries int, backend CacheBackend) WithConfig {
+	return func(db *DiscordBot) {
+		db.cache = &responseCache{
+			ttl:        ttl,
+			maxEntries: maxEntries,
+			ll:         list.New(),
+			items:      map[string]*list.Element{},
+			backend:    backend,
+		}
+	}
+}
+
+// ResponseCacheKey 缓存key: 模型与规范化后的消息(角色小写、去除首尾空白)的摘要
+func ResponseCacheKey(model string, messages []types.OpenAIChatMessage) string {
+	normalised := make([]types.OpenAIChatMessage, 0, len(messages))
+	for _, message := range messages {
+		content := message.Content
+		if text, ok := content.(string); ok {
+			content = strings.TrimSpace(text)
+		}
+		normalised = append(normalised, types.OpenAIChatMessage{
+			Role:    strings.ToLower(strings.TrimSpace(message.Role)),
+			Content: content,
+		})
+	}
+	data, _ := json.Marshal(normalised)
+	sum := sha256.Sum256(append([]byte(model+"\n"), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *responseCache) expired(entry CacheEntry) bool {
+	return c.ttl > 0 && time.Since(entry.CreatedAt) > c.ttl
+}
+
+func (c *responseCache) get(ctx context.Context, key string) (string, bool) {
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		item := el.Value.(*cacheItem)
+		if !c.expired(item.entry) {
+			c.ll.MoveToFront(el)
+			c.mu.Unlock()
+			return item.entry.Content, true
+		}
+		c.removeLocked(el)
+	}
+	c.mu.Unlock()
+
+	if c.backend == nil {
+		return "", false
+	}
+	entry, ok, err := c.backend.Get(ctx, key)
+	if err != nil {
+		ctxLogger(ctx).Warn("response cache backend get failed", zap.Error(err))
+		return "", false
+	}
+	if !ok {
+		return "", false
+	}
+	if c.expired(entry) {
+		c.backend.Delete(ctx, key)
+		return "", false
+	}
+	c.mu.Lock()
+	c.addLocked(key, entry)
+	c.mu.Unlock()
+	return entry.Content, true
+}
+
+func (c *responseCache) set(ctx context.Context, key, content string) {
+	entry := CacheEntry{Content: content, CreatedAt: time.Now()}
+	c.mu.Lock()
+	c.addLocked(key, entry)
+	c.mu.Unlock()
+
+	if c.backend != nil {
+		if err := c.backend.Set(ctx, key, entry); err != nil {
+			ctxLogger(ctx).Warn("response cache backend set failed", zap.Error(err))
+		}
+	}
+}
+
+func (c *responseCache) addLocked(key string, entry CacheEntry) {
+	if el, ok := c.items[key]; ok {
+		el.Value.(*cacheItem).entry = entry
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cacheItem{key: key, entry: entry})
+	for c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		c.removeLocked(c.ll.Back())
+	}
+}
+
+func (c *responseCache) removeLocked(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*cacheItem).key)
+}
+
+// ContextWithCacheBypass 标记该请求跳过响应缓存
+func ContextWithCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKey(CacheBypassHeader), true)
+}
+
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(ctxKey(CacheBypassHeader)).(bool)
+	return bypass
+}
+
+// CacheBypassRequested 判断请求头是否要求跳过响应缓存
+func CacheBypassRequested(header http.Header) bool {
+	switch strings.ToLower(header.Get(CacheBypassHeader)) {
+	case "1", "true":
+		return true
+	}
+	return strings.Contains(strings.ToLower(header.Get("Cache-Control")), "no-cache")
+}
+
+// FileCacheBackend 以目录下每个key一个json文件的形式持久化响应缓存
+type FileCacheBackend struct {
+	Dir string
+}
+
+func NewFileCacheBackend(dir string) (*FileCacheBackend, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileCacheBackend{Dir: dir}, nil
+}
+
+func (f *FileCacheBackend) path(key string) string {
+	return filepath.Join(f.Dir, key+".json")
+}
+
+func (f *FileCacheBackend) Get(ctx context.Context, key string) (CacheEntry, bool, error) {
+	data, err := os.ReadFile(f.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return CacheEntry{}, false, nil
+	}
+	if err != nil {
+		return CacheEntry{}, false, err
+	}
+	var entry CacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return CacheEntry{}, false, err
+	}
+	return entry, true, nil
+}
+
+func (f *FileCacheBackend) Set(ctx context.Context, key string, entry CacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp := f.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path(key))
+}
+
+func (f *FileCacheBackend) Delete(ctx context.Context, key string) error {
+	err := os.Remove(f.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
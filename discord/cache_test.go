@@ -0,0 +1,92 @@
+package discord
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/wwqdrh/gobot/types"
+)
+
+func newTestCache(ttl time.Duration, maxEntries int, backend CacheBackend) *responseCache {
+	return &responseCache{ttl: ttl, maxEntries: maxEntries, ll: list.New(), items: map[string]*list.Element{}, backend: backend}
+}
+
+func TestResponseCacheKeyNormalised(t *testing.T) {
+	a := ResponseCacheKey("gpt-4", []types.OpenAIChatMessage{{Role: "User", Content: " hello \n"}})
+	b := ResponseCacheKey("gpt-4", []types.OpenAIChatMessage{{Role: "user", Content: "hello"}})
+	if a != b {
+		t.Fatal("normalised messages should share a cache key")
+	}
+	if a == ResponseCacheKey("gpt-4o", []types.OpenAIChatMessage{{Role: "user", Content: "hello"}}) {
+		t.Fatal("different models should not share a cache key")
+	}
+}
+
+func TestResponseCacheLRU(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCache(time.Hour, 2, nil)
+	c.set(ctx, "a", "A")
+	c.set(ctx, "b", "B")
+	c.get(ctx, "a")
+	c.set(ctx, "c", "C")
+
+	if _, ok := c.get(ctx, "b"); ok {
+		t.Fatal("least recently used entry should be evicted")
+	}
+	if content, ok := c.get(ctx, "a"); !ok || content != "A" {
+		t.Fatalf("expected A, got %q %v", content, ok)
+	}
+}
+
+func TestResponseCacheBackend(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewFileCacheBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTestCache(time.Hour, 1, backend).set(ctx, "a", "A")
+
+	// 新的内存缓存从持久化后端读取
+	if content, ok := newTestCache(time.Hour, 1, backend).get(ctx, "a"); !ok || content != "A" {
+		t.Fatalf("expected A from backend, got %q %v", content, ok)
+	}
+	backend.Set(ctx, "old", CacheEntry{Content: "old", CreatedAt: time.Now().Add(-2 * time.Hour)})
+	if _, ok := newTestCache(time.Hour, 1, backend).get(ctx, "old"); ok {
+		t.Fatal("expired backend entry should be ignored")
+	}
+}
+
+func TestCacheBypassRequested(t *testing.T) {
+	header := http.Header{}
+	if CacheBypassRequested(header) {
+		t.Fatal("empty header should not bypass")
+	}
+	header.Set(CacheBypassHeader, "1")
+	if !CacheBypassRequested(header) {
+		t.Fatal("X-Cache-Bypass: 1 should bypass")
+	}
+	header = http.Header{"Cache-Control": {"no-cache"}}
+	if !CacheBypassRequested(header) {
+		t.Fatal("Cache-Control: no-cache should bypass")
+	}
+}
+
+func TestSendRequestCacheKey(t *testing.T) {
+	a, ok := sendRequest{botId: "bot-a"}.responseCacheKey("Tell me more")
+	if !ok {
+		t.Fatal("plain send should be cacheable")
+	}
+	if b, _ := (sendRequest{botId: "bot-b"}).responseCacheKey("Tell me more"); a == b {
+		t.Fatal("different bots should not share a cache key")
+	}
+	// 在指定频道中继续对话的回复依赖上下文
+	if _, ok := (sendRequest{botId: "bot-a", channelId: "123"}).responseCacheKey("Tell me more"); ok {
+		t.Fatal("send to a channel should bypass the cache")
+	}
+	if key, ok := (sendRequest{channelId: "123", cacheKey: "chat"}).responseCacheKey("Tell me more"); !ok || key != "chat" {
+		t.Fatal("explicit cache key should be used")
+	}
+}
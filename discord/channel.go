@@ -1,45 +1,34 @@
 package discord
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/wwqdrh/gokit/logger"
 )
 
-var (
-	channelTimers sync.Map // 用于存储频道ID和对应的定时器
-)
-
 // SetChannelDeleteTimer 设置或重置频道的删除定时器
 func (b *DiscordBot) SetChannelDeleteTimer(channelId string, duration time.Duration) {
-	channel, err := b.session.Channel(channelId)
+	channel, err := b.getSession().Channel(channelId)
 	// 非自动生成频道不删除
 	if err == nil && !strings.HasPrefix(channel.Name, "cdp-chat-") {
 		return
 	}
 
-	// 过滤掉配置中的频道id
-	for _, config := range BotConfigList {
-		if config.ChannelId == channelId {
-			return
-		}
-	}
-
-	// 保活id不进行删除
-	if b.defaultchannel == channelId {
+	// 过滤掉配置中的频道id与保活频道id
+	if b.isReservedChannel(channelId) {
 		return
 	}
 
 	// 检查是否已存在定时器
-	if timer, ok := channelTimers.Load(channelId); ok {
+	if timer, ok := b.life.channelTimers.Load(channelId); ok {
 		if timer.(*time.Timer).Stop() {
 			// 仅当定时器成功停止时才从映射中删除
-			channelTimers.Delete(channelId)
+			b.life.channelTimers.Delete(channelId)
 		}
 	}
 
@@ -47,20 +36,20 @@ func (b *DiscordBot) SetChannelDeleteTimer(channelId string, duration time.Durat
 	newTimer := time.AfterFunc(duration, func() {
 		b.ChannelDel(channelId)
 		// 删除完成后从map中移除
-		channelTimers.Delete(channelId)
+		b.life.channelTimers.Delete(channelId)
 	})
 	// 存储新的定时器
-	channelTimers.Store(channelId, newTimer)
+	b.life.channelTimers.Store(channelId, newTimer)
 }
 
 // CancelChannelDeleteTimer 取消频道的删除定时器
 func (b *DiscordBot) CancelChannelDeleteTimer(channelId string) {
 	// 尝试从映射中获取定时器
-	if timer, ok := channelTimers.Load(channelId); ok {
+	if timer, ok := b.life.channelTimers.Load(channelId); ok {
 		// 如果定时器存在，尝试停止它
 		if timer.(*time.Timer).Stop() {
 			// 定时器成功停止后，从映射中移除
-			channelTimers.Delete(channelId)
+			b.life.channelTimers.Delete(channelId)
 		} else {
 			logger.DefaultLogger.Error(fmt.Sprintf("定时器无法停止或已触发，频道可能已被删除:%s", channelId))
 		}
@@ -69,12 +58,26 @@ func (b *DiscordBot) CancelChannelDeleteTimer(channelId string) {
 	}
 }
 
+// isReservedChannel 配置中的频道与保活频道不允许被清理
+func (b *DiscordBot) isReservedChannel(channelId string) bool {
+	if b.defaultchannel == channelId {
+		return true
+	}
+	for _, config := range b.BotConfigs() {
+		if config.ChannelId == channelId {
+			return true
+		}
+	}
+	return false
+}
+
 func (b *DiscordBot) ChannelCreate(guildID, channelName string, channelType int) (string, error) {
 	// 创建新的频道
-	st, err := b.session.GuildChannelCreate(guildID, channelName, discordgo.ChannelType(channelType))
+	st, err := b.getSession().GuildChannelCreate(guildID, channelName, discordgo.ChannelType(channelType))
 	if err != nil {
 		return "", err
 	}
+	b.trackTempChannel(st.ID, channelName)
 	return st.ID, nil
 }
 
@@ -90,23 +93,45 @@ func GetRandomString(length int) string {
 }
 
 func (b *DiscordBot) GetSendChannelId() (sendChannelId string, err error) {
+	return b.GetSendChannelIdContext(context.Background())
+}
+
+// GetSendChannelIdContext 同 GetSendChannelId, 创建频道的span关联到ctx中的trace
+func (b *DiscordBot) GetSendChannelIdContext(ctx context.Context) (sendChannelId string, err error) {
+	_, span := startSpan(ctx, "discord.ChannelCreate")
+	defer func() {
+		span.SetAttributes(AttrChannelId.String(sendChannelId))
+		endSpan(span, err)
+	}()
+
 	key := getTimeString() + GetRandomString(8)
 	return b.CreateChannelWithRetry(b.guildID, fmt.Sprintf("cdp-chat-%s", key), 0)
 }
 
+// channelDelContext 删除频道并记录span
+func (b *DiscordBot) channelDelContext(ctx context.Context, channelId string) {
+	if channelId == "" {
+		return
+	}
+	_, span := startSpan(ctx, "discord.ChannelDelete", AttrChannelId.String(channelId))
+	_, err := b.ChannelDel(channelId)
+	endSpan(span, err)
+}
+
 func (b *DiscordBot) ChannelDel(channelId string) (string, error) {
 	// 删除频道
-	st, err := b.session.ChannelDelete(channelId)
+	st, err := b.getSession().ChannelDelete(channelId)
 	if err != nil {
 		logger.DefaultLogger.Error(fmt.Sprintf("删除频道时异常 %s", err.Error()))
 		return "", err
 	}
+	b.untrackTempChannel(channelId)
 	return st.ID, nil
 }
 
 func (b *DiscordBot) ChannelCreateComplex(guildID, parentId, channelName string, channelType int) (string, error) {
 	// 创建新的子频道
-	st, err := b.session.GuildChannelCreateComplex(guildID, discordgo.GuildChannelCreateData{
+	st, err := b.getSession().GuildChannelCreateComplex(guildID, discordgo.GuildChannelCreateData{
 		Name:     channelName,
 		Type:     discordgo.ChannelType(channelType),
 		ParentID: parentId,
@@ -177,7 +202,7 @@ func (b *DiscordBot) CreateChannelWithRetry(guildID, channelName string, channel
 
 func (b *DiscordBot) ChannelDelAllForCdp() (bool, error) {
 	// 获取服务器内所有频道的信息
-	channels, err := b.session.GuildChannels(b.guildID)
+	channels, err := b.getSession().GuildChannels(b.guildID)
 	if err != nil {
 		logger.DefaultLogger.Error(fmt.Sprintf("服务器Id查询频道失败 %s", err.Error()))
 		return false, err
@@ -188,25 +213,20 @@ func (b *DiscordBot) ChannelDelAllForCdp() (bool, error) {
 	// 遍历所有频道
 	for _, channel := range channels {
 
-		// 过滤掉配置中的频道id
-		for _, config := range BotConfigList {
-			if config.ChannelId == channel.ID {
-				continue
-			}
-		}
-
-		if b.defaultchannel == channel.ID {
+		// 过滤掉配置中的频道id与保活频道id
+		if b.isReservedChannel(channel.ID) {
 			continue
 		}
 
 		// 检查频道名是否以"cdp-"开头
 		if strings.HasPrefix(channel.Name, "cdp-chat-") {
 			// 删除该频道
-			_, err := b.session.ChannelDelete(channel.ID)
+			_, err := b.getSession().ChannelDelete(channel.ID)
 			if err != nil {
 				logger.DefaultLogger.Error(fmt.Sprintf("频道数量已满-删除频道异常(可能原因:对话请求频道已被自动删除) %s", err.Error()))
 				return false, err
 			}
+			b.untrackTempChannel(channel.ID)
 			logger.DefaultLogger.Warn(fmt.Sprintf("频道数量已满-自动删除频道Id %s", channel.ID))
 			flag = true
 		}
@@ -216,7 +236,7 @@ func (b *DiscordBot) ChannelDelAllForCdp() (bool, error) {
 
 func (b *DiscordBot) ChannelDelOldestForCdp() (bool, error) {
 	// 获取服务器内所有频道的信息
-	channels, err := b.session.GuildChannels(b.guildID)
+	channels, err := b.getSession().GuildChannels(b.guildID)
 	if err != nil {
 		logger.DefaultLogger.Error(fmt.Sprintf("服务器Id查询频道失败 %s", err.Error()))
 		return false, err
@@ -225,25 +245,20 @@ func (b *DiscordBot) ChannelDelOldestForCdp() (bool, error) {
 	// 遍历所有频道
 	for _, channel := range channels {
 
-		// 过滤掉配置中的频道id
-		for _, config := range BotConfigList {
-			if config.ChannelId == channel.ID {
-				continue
-			}
-		}
-
-		if b.defaultchannel == channel.ID {
+		// 过滤掉配置中的频道id与保活频道id
+		if b.isReservedChannel(channel.ID) {
 			continue
 		}
 
 		// 检查频道名是否以"cdp-"开头
 		if strings.HasPrefix(channel.Name, "cdp-chat-") {
 			// 删除该频道
-			_, err := b.session.ChannelDelete(channel.ID)
+			_, err := b.getSession().ChannelDelete(channel.ID)
 			if err != nil {
 				logger.DefaultLogger.Error(fmt.Sprintf("频道数量已满-删除频道异常(可能原因:对话请求频道已被自动删除) %s", err.Error()))
 				return false, err
 			}
+			b.untrackTempChannel(channel.ID)
 			logger.DefaultLogger.Warn(fmt.Sprintf("频道数量已满-自动删除频道Id %s", channel.ID))
 			return true, nil
 		}
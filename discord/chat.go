@@ -0,0 +1,168 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wwqdrh/gobot/types"
+	"go.uber.org/zap"
+)
+
+// ChatCompletion 以 OpenAI 格式发起对话: 根据 req.Model 解析别名得到候选模型链, 依次选择对应的 BotConfig
+// 并@该bot等待回复; 当前候选报错或达到每日上限时自动尝试下一个. secret 为调用方使用的 proxySecret, 为空时不按 secret 过滤.
+// 超出 secret 的配额时返回 *QuotaExceededError, 成功的请求计入用量账本. 开启响应缓存时相同请求直接返回缓存并标记 Cached
+func (b *DiscordBot) ChatCompletion(ctx context.Context, secret string, req types.OpenAIChatCompletionRequest) (types.OpenAIChatCompletionResponse, error) {
+	return b.chatCompletion(ctx, secret, req, nil)
+}
+
+// ChatCompletionStream 同 ChatCompletion, 回复过程中通过 send 以 chat.completion.chunk 的形式输出增量内容,
+// bot 以多条消息回复时按消息顺序合并输出; 成功时最后一块带 finish_reason 与 usage. 已输出内容后不再尝试其他候选模型.
+// 已输出的内容之后被修改(如较早的消息被编辑)时无法以增量表达, 输出的内容与返回值不一致
+func (b *DiscordBot) ChatCompletionStream(ctx context.Context, secret string, req types.OpenAIChatCompletionRequest, send func(types.OpenAIChatCompletionResponse)) (types.OpenAIChatCompletionResponse, error) {
+	ctx = ensureRequestId(ctx)
+	stream := &deltaStream{}
+	stream.send = func(delta string) {
+		send(newChatCompletionChunk(ctx, stream.model, delta))
+	}
+	resp, err := b.chatCompletion(ctx, secret, req, stream)
+	if err != nil {
+		return resp, err
+	}
+	// 缓存命中或尚未输出的新增部分在最后补齐. 已输出的内容被修改时无法撤回, 流式内容与 resp 及 usage 不一致
+	stream.update(resp.Choices[0].Message.Content)
+	if stream.diverged {
+		ctxLogger(ctx).Warn("streamed content differs from the final reply", zap.Int("emitted", len(stream.emitted)))
+	}
+	final := newChatCompletionChunk(ctx, resp.Model, "")
+	final.Choices[0].FinishReason = resp.Choices[0].FinishReason
+	final.Usage = resp.Usage
+	send(final)
+	return resp, nil
+}
+
+func (b *DiscordBot) chatCompletion(ctx context.Context, secret string, req types.OpenAIChatCompletionRequest, stream *deltaStream) (types.OpenAIChatCompletionResponse, error) {
+	ctx = withLogFields(ensureRequestId(ctx), zap.String("model", req.Model))
+	// usage 按原始请求消息与请求模型的编码统计, 与路由到哪个别名目标以及 prompt 被拆分成几段发送无关
+	promptTokens, err := CountMessageTokens(req.Model, req.Messages)
+	if err != nil {
+		ctxLogger(ctx).Warn("count prompt tokens failed", zap.Error(err))
+	}
+	// 发送前预留配额, 成功后以实际用量替换, 失败时归还
+	reservation, err := b.usage.reserve(secret, Usage{Requests: 1, PromptTokens: promptTokens})
+	if err != nil {
+		return types.OpenAIChatCompletionResponse{}, err
+	}
+	defer reservation.cancel()
+
+	prompt, err := buildPrompt(req.Messages)
+	if err != nil {
+		return types.OpenAIChatCompletionResponse{}, err
+	}
+
+	cacheKey := ResponseCacheKey(req.Model, req.Messages)
+	var lastErr error
+	for _, model := range resolveModelChain(b.modelAliases, req.Model) {
+		config, err := b.SelectBotConfig(secret, model, req.ChannelId)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if stream != nil {
+			stream.model = model
+		}
+		result, err := b.sendPlain(ctx, sendRequest{
+			botId:        config.BotId,
+			channelId:    config.ChannelId,
+			model:        model,
+			tokenModel:   req.Model,
+			promptTokens: promptTokens,
+			cacheKey:     cacheKey,
+			completion:   b.completionConfig(config.Completion),
+			stream:       stream,
+		}, prompt)
+		if err == nil && SliceContains(CozeErrorMessages, result.Content) {
+			err = &CozeReplyError{Message: result.Content, DailyLimit: SliceContains(CozeDailyLimitErrorMessages, result.Content)}
+		}
+		if err == nil {
+			content := result.Content
+			resp := newChatCompletionResponse(ctx, model, promptTokens, countTokensOrZero(req.Model, content), content)
+			resp.Cached = result.Cached
+			resp.Suggestions = result.Suggestions
+			reservation.commit(Usage{
+				Requests:         1,
+				PromptTokens:     resp.Usage.PromptTokens,
+				CompletionTokens: resp.Usage.CompletionTokens,
+				Images:           result.imageCount(),
+			})
+			return resp, nil
+		}
+		if ctx.Err() != nil || errors.Is(err, ErrBotClosed) || (stream != nil && stream.emitted != "") {
+			return types.OpenAIChatCompletionResponse{}, err
+		}
+		ctxLogger(ctx).Warn("model failed, trying next fallback", zap.String("target_model", model), botField(config.BotId), zap.Error(err))
+		lastErr = err
+	}
+	return types.OpenAIChatCompletionResponse{}, lastErr
+}
+
+func newChatCompletionResponse(ctx context.Context, model string, promptTokens, completionTokens int, content string) types.OpenAIChatCompletionResponse {
+	stopStr := "stop"
+	return types.OpenAIChatCompletionResponse{
+		ID:      "chatcmpl-" + RequestIdFromContext(ctx),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []types.OpenAIChoice{
+			{
+				Index: 0,
+				Message: types.OpenAIMessage{
+					Role:    "assistant",
+					Content: content,
+				},
+				FinishReason: &stopStr,
+			},
+		},
+		Usage: types.OpenAIUsage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		},
+	}
+}
+
+// newChatCompletionChunk 流式输出中的一块, delta 为新增的内容
+func newChatCompletionChunk(ctx context.Context, model, delta string) types.OpenAIChatCompletionResponse {
+	return types.OpenAIChatCompletionResponse{
+		ID:      "chatcmpl-" + RequestIdFromContext(ctx),
+		Object:  "chat.completion.chunk",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []types.OpenAIChoice{
+			{
+				Index: 0,
+				Delta: types.OpenAIDelta{Content: delta},
+			},
+		},
+	}
+}
+
+// buildPrompt 单条纯文本消息直接发送, 多轮对话或多模态内容以json形式发送
+func buildPrompt(messages []types.OpenAIChatMessage) (string, error) {
+	if len(messages) == 0 {
+		return "", fmt.Errorf("messages is required")
+	}
+	if len(messages) == 1 {
+		if text, ok := messages[0].Content.(string); ok {
+			return text, nil
+		}
+	}
+	data, err := json.Marshal(messages)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,124 @@
+package discord
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// CompletionConfig 判定bot回复完成的条件, 回复带有建议按钮时总是视为完成.
+// 为0的字段使用 DefaultCompletionConfig 中的值
+type CompletionConfig struct {
+	// QuiescenceMs 收到回复后超过该时长没有新的编辑且bot不在输入中即视为完成. 默认不启用,
+	// Coze 调用插件时常有较长停顿, 需按bot确认后开启; 为负数时关闭全局配置中开启的判定
+	QuiescenceMs int `json:"quiescenceMs,omitempty"`
+	// TypingMs 收到输入中事件后视为bot仍在回复的时长
+	TypingMs int `json:"typingMs,omitempty"`
+	// EndMarker 回复以该标记结尾时视为完成, 标记不会出现在返回的内容中
+	EndMarker string `json:"endMarker,omitempty"`
+	// TimeoutSec 超过该时长没有任何回复活动时停止等待: 已收到回复时以收到的内容作为完整回复, 否则返回错误
+	TimeoutSec int `json:"timeoutSec,omitempty"`
+}
+
+var DefaultCompletionConfig = CompletionConfig{
+	TypingMs:   10000,
+	TimeoutSec: 60,
+}
+
+// WithCompletion 未在 BotConfig 中单独设置时使用的回复完成判定条件
+func WithCompletion(config CompletionConfig) WithConfig {
+	return func(db *DiscordBot) {
+		db.completion = config
+	}
+}
+
+// merge 以 config 中非零的字段覆盖 c
+func (c CompletionConfig) merge(config *CompletionConfig) CompletionConfig {
+	if config == nil {
+		return c
+	}
+	if config.QuiescenceMs != 0 {
+		c.QuiescenceMs = config.QuiescenceMs
+	}
+	if config.TypingMs != 0 {
+		c.TypingMs = config.TypingMs
+	}
+	if config.EndMarker != "" {
+		c.EndMarker = config.EndMarker
+	}
+	if config.TimeoutSec != 0 {
+		c.TimeoutSec = config.TimeoutSec
+	}
+	return c
+}
+
+// completionConfig bot 生效的完成判定条件: 默认值 < WithCompletion < BotConfig.Completion
+func (b *DiscordBot) completionConfig(config *CompletionConfig) CompletionConfig {
+	return DefaultCompletionConfig.merge(&b.completion).merge(config)
+}
+
+// completionDetector 根据回复内容、编辑间隔与输入中事件判断回复是否完成
+type completionDetector struct {
+	config       CompletionConfig
+	content      string
+	replied      bool
+	lastActivity time.Time
+	typingUntil  time.Time
+}
+
+func newCompletionDetector(config CompletionConfig, now time.Time) *completionDetector {
+	return &completionDetector{config: config, lastActivity: now}
+}
+
+// reply 记录最新的回复内容, 以结束标记结尾时返回去除标记后的内容与 true
+func (d *completionDetector) reply(content string, now time.Time) (string, bool) {
+	d.replied = true
+	d.lastActivity = now
+	d.typingUntil = time.Time{}
+	if marker := d.config.EndMarker; marker != "" {
+		trimmed := strings.TrimRightFunc(content, func(r rune) bool { return r == ' ' || r == '\n' })
+		if strings.HasSuffix(trimmed, marker) {
+			d.content = strings.TrimRightFunc(strings.TrimSuffix(trimmed, marker), func(r rune) bool { return r == ' ' || r == '\n' })
+			return d.content, true
+		}
+	}
+	d.content = content
+	return content, false
+}
+
+// typing 记录bot的输入中事件
+func (d *completionDetector) typing(now time.Time) {
+	d.lastActivity = now
+	d.typingUntil = now.Add(time.Duration(d.config.TypingMs) * time.Millisecond)
+}
+
+// next 距下一次需要检查的时长; done 表示回复已完成(编辑静默, 或已收到回复后超过 TimeoutSec 没有活动),
+// timeout 表示没有收到任何回复而超时
+func (d *completionDetector) next(now time.Time) (wait time.Duration, done, timeout bool) {
+	deadline := d.lastActivity.Add(time.Duration(d.config.TimeoutSec) * time.Second)
+	if d.typingUntil.After(deadline) {
+		deadline = d.typingUntil
+	}
+	if !now.Before(deadline) {
+		return 0, d.replied, !d.replied
+	}
+	wait = deadline.Sub(now)
+
+	if d.replied && d.config.QuiescenceMs > 0 {
+		quiet := d.lastActivity.Add(time.Duration(d.config.QuiescenceMs) * time.Millisecond)
+		if d.typingUntil.After(quiet) {
+			quiet = d.typingUntil
+		}
+		if !now.Before(quiet) {
+			return 0, true, false
+		}
+		wait = min(wait, quiet.Sub(now))
+	}
+	return wait, false, false
+}
+
+// typingStart 将bot的输入中事件通知给同频道等待该bot回复的请求
+func (b *DiscordBot) typingStart(s *discordgo.Session, t *discordgo.TypingStart) {
+	b.replies.typing(t.ChannelID, t.UserID)
+}
@@ -0,0 +1,77 @@
+package discord
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompletionDetector(t *testing.T) {
+	now := time.Now()
+	config := DefaultCompletionConfig.merge(&CompletionConfig{QuiescenceMs: 2000, EndMarker: "[END]"})
+	d := newCompletionDetector(config, now)
+
+	if _, done, timeout := d.next(now.Add(10 * time.Second)); done || timeout {
+		t.Fatal("should keep waiting before any reply")
+	}
+	if _, _, timeout := d.next(now.Add(61 * time.Second)); !timeout {
+		t.Fatal("should time out without any reply")
+	}
+
+	d.reply("hello", now)
+	if wait, done, _ := d.next(now.Add(time.Second)); done || wait != time.Second {
+		t.Fatalf("should wait for the rest of the quiescence window, got %v %v", wait, done)
+	}
+	// 输入中事件延长静默窗口
+	d.typing(now.Add(time.Second))
+	if _, done, _ := d.next(now.Add(5 * time.Second)); done {
+		t.Fatal("should not complete while the bot is typing")
+	}
+	if _, done, _ := d.next(now.Add(12 * time.Second)); !done {
+		t.Fatal("should complete once typing expired and edits stopped")
+	}
+
+	if content, done := d.reply("hello world [END]\n", now); !done || content != "hello world" {
+		t.Fatalf("end marker not detected: %q %v", content, done)
+	}
+}
+
+func TestCompletionConfigMerge(t *testing.T) {
+	b := NewDiscordBot("", WithCompletion(CompletionConfig{TimeoutSec: 120}))
+	config := b.completionConfig(&CompletionConfig{QuiescenceMs: -1})
+	if config.TimeoutSec != 120 || config.QuiescenceMs != -1 || config.TypingMs != DefaultCompletionConfig.TypingMs {
+		t.Fatalf("unexpected merged config: %+v", config)
+	}
+	d := newCompletionDetector(config, time.Now())
+	d.reply("hello", time.Now())
+	if _, done, _ := d.next(time.Now().Add(time.Minute)); done {
+		t.Fatal("quiescence should be disabled")
+	}
+}
+
+func TestCompletionDefaultWaitsThroughPauses(t *testing.T) {
+	now := time.Now()
+	d := newCompletionDetector(NewDiscordBot("").completionConfig(nil), now)
+	d.reply("calling plugin...", now)
+	// 插件调用期间长时间没有编辑, 默认配置下不视为完成
+	if _, done, timeout := d.next(now.Add(30 * time.Second)); done || timeout {
+		t.Fatalf("a long pause should not end the reply by default: done=%v timeout=%v", done, timeout)
+	}
+	if content, _ := d.reply("calling plugin... done", now.Add(45*time.Second)); content != "calling plugin... done" {
+		t.Fatalf("unexpected content: %q", content)
+	}
+}
+
+func TestCompletionDefaultReturnsReplyAfterInactivity(t *testing.T) {
+	now := time.Now()
+	d := newCompletionDetector(NewDiscordBot("").completionConfig(nil), now)
+	d.reply("partial answer", now)
+	d.typing(now.Add(5 * time.Second))
+	// 输入中事件延长等待
+	if _, done, timeout := d.next(now.Add(64 * time.Second)); done || timeout {
+		t.Fatalf("typing should extend the wait: done=%v timeout=%v", done, timeout)
+	}
+	// 没有建议按钮与结束标记的回复在 TimeoutSec 没有活动后完成, 而不是超时丢弃
+	if _, done, timeout := d.next(now.Add(66 * time.Second)); !done || timeout {
+		t.Fatalf("received reply should complete after inactivity: done=%v timeout=%v", done, timeout)
+	}
+}
@@ -0,0 +1,166 @@
+package discord
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// replyRegistry 将bot回复关联到等待中的请求. 请求在发送第一段之前登记, 每段发送后即加入,
+// 回复引用任意一段prompt时都能找到请求, 即使后续分段仍在发送中; 回复没有引用时按频道与bot作者兜底匹配
+type replyRegistry struct {
+	mu       sync.Mutex
+	seq      uint64
+	requests map[string]*correlation   // 请求key -> 请求
+	prompts  map[string]*correlation   // prompt分段的消息ID -> 请求
+	replies  map[string]*correlation   // 已关联的回复消息ID -> 请求
+	channels map[string][]*correlation // 频道ID -> 等待中的请求, 按登记顺序
+}
+
+// correlation 等待回复的请求
+type correlation struct {
+	key       string
+	channelId string
+	botId     string
+	ids       []string // prompt分段的消息ID
+	replyIds  []string
+	typingC   chan struct{} // bot 输入中事件
+	done      chan struct{} // 请求结束后关闭, 避免回复处理阻塞在无人接收的channel上
+}
+
+func newReplyRegistry() *replyRegistry {
+	return &replyRegistry{
+		requests: make(map[string]*correlation),
+		prompts:  make(map[string]*correlation),
+		replies:  make(map[string]*correlation),
+		channels: make(map[string][]*correlation),
+	}
+}
+
+// register 在发送之前登记等待 botId 回复的请求
+func (r *replyRegistry) register(botId string) *correlation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	c := &correlation{
+		key:     "req-" + strconv.FormatUint(r.seq, 10),
+		botId:   botId,
+		typingC: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	r.requests[c.key] = c
+	return c
+}
+
+// addPrompt 登记请求已发送的一段消息, 第一段发送后请求才加入所在频道的兜底匹配
+func (r *replyRegistry) addPrompt(c *correlation, channelId, messageId string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.requests[c.key]; !ok {
+		return
+	}
+	if c.channelId == "" {
+		c.channelId = channelId
+		r.channels[channelId] = append(r.channels[channelId], c)
+	}
+	c.ids = append(c.ids, messageId)
+	r.prompts[messageId] = c
+}
+
+// unregister 请求结束后移除其分段与回复的关联
+func (r *replyRegistry) unregister(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.requests[key]
+	if !ok {
+		return
+	}
+	delete(r.requests, key)
+	close(c.done)
+	for _, id := range c.ids {
+		delete(r.prompts, id)
+	}
+	for _, id := range c.replyIds {
+		delete(r.replies, id)
+	}
+	if c.channelId == "" {
+		return
+	}
+	pending := r.channels[c.channelId]
+	for i, other := range pending {
+		if other == c {
+			pending = append(pending[:i], pending[i+1:]...)
+			break
+		}
+	}
+	if len(pending) == 0 {
+		delete(r.channels, c.channelId)
+	} else {
+		r.channels[c.channelId] = pending
+	}
+}
+
+// resolve 返回消息所属请求的key. 依次按引用的消息、已关联的回复ID、
+// 同频道中最早登记且@了该作者的请求匹配, 匹配成功后记住回复ID以便关联其后续编辑
+func (r *replyRegistry) resolve(m *discordgo.Message) (*correlation, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var c *correlation
+	if ref := referencedId(m); ref != "" {
+		c = r.prompts[ref]
+	}
+	if c == nil {
+		c = r.replies[m.ID]
+	}
+	if c == nil && m.Author != nil {
+		for _, pending := range r.channels[m.ChannelID] {
+			if pending.botId == m.Author.ID {
+				c = pending
+				break
+			}
+		}
+	}
+	if c == nil {
+		return nil, false
+	}
+	if _, ok := r.replies[m.ID]; !ok {
+		r.replies[m.ID] = c
+		c.replyIds = append(c.replyIds, m.ID)
+	}
+	return c, true
+}
+
+// typing 通知同频道中等待 userId 回复的请求
+func (r *replyRegistry) typing(channelId, userId string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.channels[channelId] {
+		if c.botId == userId {
+			select {
+			case c.typingC <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// deliver 将回复交给等待中的请求, 请求已结束时丢弃
+func deliver[T any](ch chan T, v T, done <-chan struct{}) {
+	select {
+	case ch <- v:
+	case <-done:
+	}
+}
+
+// referencedId 消息引用的消息ID, 编辑事件中可能只有 MessageReference
+func referencedId(m *discordgo.Message) string {
+	if m.ReferencedMessage != nil {
+		return m.ReferencedMessage.ID
+	}
+	if m.MessageReference != nil {
+		return m.MessageReference.MessageID
+	}
+	return ""
+}
@@ -0,0 +1,48 @@
+package discord
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestReplyRegistryResolve(t *testing.T) {
+	r := newReplyRegistry()
+	c := r.register("bot")
+	key := c.key
+
+	// 第一段发送后, 后续分段仍在发送中时回复即可被关联
+	r.addPrompt(c, "channel", "1")
+	reply := &discordgo.Message{ID: "10", ChannelID: "channel", Author: &discordgo.User{ID: "bot"},
+		ReferencedMessage: &discordgo.Message{ID: "1"}}
+	if got, ok := r.resolve(reply); !ok || got.key != key {
+		t.Fatalf("reply to the first chunk not correlated while sending: %+v %v", got, ok)
+	}
+	r.addPrompt(c, "channel", "2")
+	r.addPrompt(c, "channel", "3")
+
+	// 编辑事件只带消息ID
+	if got, ok := r.resolve(&discordgo.Message{ID: "10", ChannelID: "channel"}); !ok || got.key != key {
+		t.Fatal("edit of a correlated reply not resolved")
+	}
+	// 没有引用时按频道与bot作者兜底
+	if got, ok := r.resolve(&discordgo.Message{ID: "11", ChannelID: "channel", Author: &discordgo.User{ID: "bot"}}); !ok || got.key != key {
+		t.Fatal("unreferenced bot reply not correlated")
+	}
+	if _, ok := r.resolve(&discordgo.Message{ID: "12", ChannelID: "channel", Author: &discordgo.User{ID: "someone"}}); ok {
+		t.Fatal("message from another author should not be correlated")
+	}
+
+	r.unregister(key)
+	if _, ok := r.resolve(reply); ok {
+		t.Fatal("unregistered request should not be resolved")
+	}
+	if len(r.requests) != 0 || len(r.prompts) != 0 || len(r.replies) != 0 || len(r.channels) != 0 {
+		t.Fatal("registry should be empty after unregister")
+	}
+	// 请求结束后发送完成的分段不再登记
+	r.addPrompt(c, "channel", "4")
+	if len(r.prompts) != 0 {
+		t.Fatal("prompts of a finished request should be ignored")
+	}
+}
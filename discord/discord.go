@@ -4,26 +4,22 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
 	"math/rand"
-	"net"
 	"net/http"
 	"net/url"
-	"os"
-	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
-	"syscall"
+	"sync/atomic"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/h2non/filetype"
-	"github.com/pkoukk/tiktoken-go"
 	"github.com/sony/sonyflake"
 	"github.com/wwqdrh/gokit/logger"
-	"golang.org/x/net/proxy"
+	"go.uber.org/zap"
 )
 
 var Version = "v4.5.3" // this hard coding will be replaced automatically when building, no need to manually change
@@ -62,8 +58,9 @@ var CozeDailyLimitErrorMessages = []string{
 
 // snowflakeGenerator 单例
 var (
-	generator *SnowflakeGenerator
-	once      sync.Once
+	generator    *SnowflakeGenerator
+	generatorErr error
+	once         sync.Once
 )
 
 // SnowflakeGenerator 是雪花ID生成器的封装
@@ -79,12 +76,16 @@ func NextID() (string, error) {
 		}
 		flake := sonyflake.NewSonyflake(st)
 		if flake == nil {
-			logger.DefaultLogger.Fatal("sonyflake not created")
+			generatorErr = errors.New("sonyflake not created")
+			return
 		}
 		generator = &SnowflakeGenerator{
 			flake: flake,
 		}
 	})
+	if generatorErr != nil {
+		return "", generatorErr
+	}
 	id, err := generator.flake.NextID()
 	if err != nil {
 		return "", err
@@ -92,24 +93,6 @@ func NextID() (string, error) {
 	return fmt.Sprintf("%d", id), nil
 }
 
-var (
-	Tke *tiktoken.Tiktoken
-)
-
-func init() {
-	// gpt-4-turbo encoding
-	tke, err := tiktoken.GetEncoding("cl100k_base")
-	if err != nil {
-		logger.DefaultLogger.Fatal(err.Error())
-	}
-	Tke = tke
-
-}
-
-func CountTokens(text string) int {
-	return len(Tke.Encode(text, nil, nil))
-}
-
 type ModelNotFoundError struct {
 	Message string
 	ErrCode int
@@ -131,11 +114,25 @@ func (e *DiscordUnauthorizedError) Error() string {
 	return fmt.Sprintf("errCode: %v, message: %v", e.ErrCode, e.Message)
 }
 
+// CozeReplyError bot 回复了 CozeErrorMessages 中的错误信息
+type CozeReplyError struct {
+	Message    string
+	DailyLimit bool
+}
+
+func (e *CozeReplyError) Error() string {
+	return fmt.Sprintf("coze reply error: %v", e.Message)
+}
+
 type BotConfig struct {
+	Id          string   `json:"id"`
 	ProxySecret string   `json:"proxySecret"`
 	BotId       string   `json:"botId"`
 	Model       []string `json:"model"`
 	ChannelId   string   `json:"channelId"`
+	Weight      int      `json:"weight"` // 同一模型多个候选时的随机权重, <=0 视为1
+	// Completion 该bot回复完成的判定条件, 为空时使用全局配置
+	Completion *CompletionConfig `json:"completion,omitempty"`
 }
 
 // FilterUniqueBotChannel 给定BotConfig切片,筛选出具有不同CozeBotId+ChannelId组合的元素
@@ -155,43 +152,72 @@ func FilterUniqueBotChannel(configs []BotConfig) []BotConfig {
 }
 
 type DiscordBot struct {
-	authorization      string
-	authorizations     []string
-	proxySecret        string
-	proxySecrets       []string
-	channelAutoDelTime string
-	proxyurl           string
-	botID              string
-	botToken           string
-	botAlive           string
-	defaultchannel     string
-	guildID            string
-	userAgent          string
-	rateLimit          int
-	rateLimitDuration  int64
-	maxChannelDelType  string // all oldest
+	auths                  *authPool
+	authStorePath          string
+	botConfigs             *botConfigStore
+	botConfigStorePath     string
+	modelAliases           []ModelAlias
+	usage                  *usageLedger
+	usageStorePath         string
+	cache                  *responseCache
+	queue                  *admissionQueue
+	rateLimits             *rateLimiter
+	transportConfig        TransportConfig
+	transportOnce          sync.Once
+	transport              *transport
+	transportErr           error
+	proxySecret            string
+	proxySecrets           []string
+	channelAutoDelTime     string
+	proxyurl               string
+	botID                  string
+	botToken               string
+	botAlive               string
+	defaultchannel         string
+	guildID                string
+	userAgent              string
+	rateLimit              int
+	rateLimitDuration      int64
+	maxChannelDelType      string // all oldest
+	promptAttachmentConfig PromptAttachmentConfig
+	completion             CompletionConfig
+	adminToken             string
 
 	started                 chan struct{}
-	session                 *discordgo.Session
 	repliesChans            *sync.Map // map[string]chan ReplyResp
-	repliesOpenAIChans      *sync.Map //map[string]chan OpenAIChatCompletionResponse
+	repliesOpenAIChans      *sync.Map //map[string]chan replyUpdate
 	repliesOpenAIImageChans *sync.Map //map[string]chan OpenAIImagesGenerationResponse
 	replyStopChans          *sync.Map //map[string]chan ChannelStopChan
+	pendingReplies          *sync.Map //map[string]*pendingReply
+	replies                 *replyRegistry
+	life                    *lifecycle
+	gateway                 *gatewaySupervisor
+	guildChannelsCache      guildChannelsCache
+
+	// session 由 Start 创建, 健康检查等可能与启动并发, 通过 getSession 读取
+	session atomic.Pointer[discordgo.Session]
 }
 
 type WithConfig func(*DiscordBot)
 
 func NewDiscordBot(auth string, conf ...WithConfig) *DiscordBot {
 	b := &DiscordBot{
-		authorization:           auth,
-		authorizations:          strings.Split(auth, ","),
+		auths:                   newAuthPool(auth),
+		botConfigs:              &botConfigStore{},
+		usage:                   newUsageLedger(),
+		rateLimits:              newRateLimiter(),
+		transportConfig:         DefaultTransportConfig,
 		rateLimit:               60,
 		rateLimitDuration:       1 * 60,
 		started:                 make(chan struct{}),
 		repliesChans:            &sync.Map{}, //make(map[string]chan ReplyResp),
-		repliesOpenAIChans:      &sync.Map{}, //make(map[string]chan OpenAIChatCompletionResponse),
+		repliesOpenAIChans:      &sync.Map{}, //make(map[string]chan replyUpdate),
 		repliesOpenAIImageChans: &sync.Map{}, //make(map[string]chan OpenAIImagesGenerationResponse),
 		replyStopChans:          &sync.Map{}, //make(map[string]chan ChannelStopChan),
+		pendingReplies:          &sync.Map{}, //make(map[string]*pendingReply),
+		replies:                 newReplyRegistry(),
+		life:                    newLifecycle(),
+		gateway:                 newGatewaySupervisor(),
 	}
 	for _, c := range conf {
 		c(b)
@@ -262,67 +288,138 @@ func WithBotAlive(alive string) WithConfig {
 		db.botAlive = alive
 	}
 }
-func (b *DiscordBot) Check() {
-	if b.proxyurl != "" {
-		_, _, err := NewProxyClient(b.proxyurl)
-		if err != nil {
-			logger.DefaultLogger.Fatal("环境变量 PROXY_URL 设置有误")
-		}
+
+// ConfigError 汇总所有配置问题
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "配置校验未通过: " + strings.Join(e.Problems, "; ")
+}
+
+// Check 校验配置, 返回包含全部问题的 *ConfigError. 会话已建立时还会校验 COZE_BOT_ID 与当前 bot 是否冲突
+func (b *DiscordBot) Check() error {
+	if problems := b.configProblems(); len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	logger.DefaultLogger.Info("Environment variable check passed.")
+	return nil
+}
+
+const botIdConflictProblem = "环境变量 COZE_BOT_ID 不可为当前服务 BOT_TOKEN 关联的 BOT_ID"
+
+// botIdConflict 会话已建立且 COZE_BOT_ID 为当前服务的 bot
+func (b *DiscordBot) botIdConflict() bool {
+	session := b.getSession()
+	return session != nil && session.State.User != nil && session.State.User.ID == b.botID
+}
+
+func (b *DiscordBot) configProblems() []string {
+	var problems []string
+	if b.botToken == "" {
+		problems = append(problems, "环境变量 BOT_TOKEN 未设置")
+	}
+	if b.auths.count() == 0 {
+		problems = append(problems, "环境变量 USER_AUTHORIZATION 未设置")
+	}
+	if _, err := b.httpTransport(); err != nil {
+		problems = append(problems, "环境变量 PROXY_URL 设置有误: "+err.Error())
 	}
 	if b.guildID == "" {
-		logger.DefaultLogger.Fatal("环境变量 GUILD_ID 未设置")
+		problems = append(problems, "环境变量 GUILD_ID 未设置")
 	}
 
 	if b.botID == "" {
-		logger.DefaultLogger.Fatal("环境变量 COZE_BOT_ID 未设置")
-	} else if b.session.State.User.ID == b.botID {
-		logger.DefaultLogger.Fatal("环境变量 COZE_BOT_ID 不可为当前服务 BOT_TOKEN 关联的 BOT_ID")
+		problems = append(problems, "环境变量 COZE_BOT_ID 未设置")
+	} else if b.botIdConflict() {
+		problems = append(problems, botIdConflictProblem)
 	}
 
 	if b.channelAutoDelTime != "" {
 		_, err := strconv.Atoi(b.channelAutoDelTime)
 		if err != nil {
-			logger.DefaultLogger.Fatal("环境变量 CHANNEL_AUTO_DEL_TIME 设置有误")
+			problems = append(problems, "环境变量 CHANNEL_AUTO_DEL_TIME 设置有误")
 		}
 	}
 
-	logger.DefaultLogger.Info("Environment variable check passed.")
+	return problems
 }
 
-func (b *DiscordBot) StartBot(ctx context.Context) {
-	var err error
-	b.session, err = discordgo.New("Bot " + b.botToken)
-
-	if err != nil {
-		logger.DefaultLogger.Fatal("error creating Discord session," + err.Error())
-		return
+// Start 建立discord连接、校验配置并启动后台任务, 成功后立即返回.
+// ctx 取消后会以 ShutdownTimeout 为期限自动调用 Shutdown. 重复调用返回 ErrBotStarted, 启动失败后可重试
+func (b *DiscordBot) Start(ctx context.Context) (err error) {
+	if err := b.life.beginStart(); err != nil {
+		return err
 	}
-
-	if b.proxyurl != "" {
-		proxyParse, client, err := NewProxyClient(b.proxyurl)
+	defer func() {
 		if err != nil {
-			logger.DefaultLogger.Fatal("error creating proxy client," + err.Error())
+			b.session.Store(nil)
+			b.life.abortStart()
 		}
-		b.session.Client = client
-		b.session.Dialer.Proxy = http.ProxyURL(proxyParse)
+	}()
+	if b.authStorePath != "" {
+		if err := b.auths.load(b.authStorePath); err != nil {
+			return fmt.Errorf("error loading user authorizations: %w", err)
+		}
+	}
+	if err := b.botConfigs.load(BotConfigList, b.botConfigStorePath); err != nil {
+		return fmt.Errorf("error loading bot configs: %w", err)
+	}
+	if b.usageStorePath != "" {
+		if err := b.usage.load(b.usageStorePath); err != nil {
+			return fmt.Errorf("error loading usage ledger: %w", err)
+		}
+	}
+	// 验证docker配置文件, 校验失败时不建立连接
+	if err := b.Check(); err != nil {
+		return err
+	}
+	session, err := discordgo.New("Bot " + b.botToken)
+	if err != nil {
+		return fmt.Errorf("error creating Discord session: %w", err)
+	}
+
+	// session、用户token请求与附件下载共用同一传输层
+	t, err := b.httpTransport()
+	if err != nil {
+		return fmt.Errorf("error creating http transport: %w", err)
+	}
+	session.Client = t.client
+	session.Dialer = t.websocketDialer()
+	if b.proxyurl != "" || b.transportConfig.ProxyURL != "" {
 		logger.DefaultLogger.Info("Proxy Set Success!")
 	}
+	// 断线重连由 gatewaySupervisor 负责
+	session.ShouldReconnectOnError = false
+	session.AddHandler(b.onReady)
+	session.AddHandler(b.onResumed)
+	session.AddHandler(b.onDisconnect)
+
 	// 注册消息处理函数
-	b.session.AddHandler(b.messageCreate)
-	b.session.AddHandler(b.messageUpdate)
+	session.AddHandler(b.messageCreate)
+	session.AddHandler(b.messageUpdate)
+	session.AddHandler(b.typingStart)
 
-	// 打开websocket连接并开始监听
-	err = b.session.Open()
+	// 打开websocket连接并开始监听, 连接过程中的事件处理同样需要 session
+	b.session.Store(session)
+	err = session.Open()
 	if err != nil {
-		logger.DefaultLogger.Fatal("error opening connection," + err.Error())
-		return
+		return fmt.Errorf("error opening connection: %w", err)
+	}
+	// COZE_BOT_ID 是否与当前 bot 冲突需在连接后才能判断
+	if b.botIdConflict() {
+		b.gateway.setState(GatewayClosed)
+		b.getSession().Close()
+		return &ConfigError{Problems: []string{botIdConflictProblem}}
 	}
-	// 验证docker配置文件
-	b.Check()
 	logger.DefaultLogger.Info("Bot is now running. Enjoy It.")
 
 	// 每日9点 重新加载userAuth
 	go b.loadUserAuthTask()
+	if b.usageStorePath != "" {
+		go b.usageFlushTask()
+	}
 
 	if b.botAlive == "1" || b.botAlive == "" {
 		// 开启coze保活任务
@@ -330,22 +427,31 @@ func (b *DiscordBot) StartBot(ctx context.Context) {
 	}
 
 	go func() {
+		// 退出信号由调用方处理, ctx取消后优雅退出
 		<-ctx.Done()
-		if err := b.session.Close(); err != nil {
-			logger.DefaultLogger.Fatal("error closing Discord session," + err.Error())
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		if err := b.Shutdown(shutdownCtx); err != nil {
+			logger.DefaultLogger.Error("error shutting down Discord bot", zap.Error(err))
 		}
 	}()
 
-	// 等待信号
 	close(b.started)
-	sc := make(chan os.Signal, 1)
-	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, syscall.SIGTERM)
-	<-sc
+	return nil
+}
+
+// StartBot 启动bot并阻塞直到ctx取消后的退出流程结束, 启动失败时记录错误并返回
+func (b *DiscordBot) StartBot(ctx context.Context) {
+	if err := b.Start(ctx); err != nil {
+		logger.DefaultLogger.Error("error starting Discord bot", zap.Error(err))
+		return
+	}
+	<-b.life.stopped
 }
 
 func (b *DiscordBot) ThreadStart(channelId, threadName string, archiveDuration int) (string, error) {
 	// 创建新的线程
-	th, err := b.session.ThreadStart(channelId, threadName, discordgo.ChannelTypeGuildText, archiveDuration)
+	th, err := b.getSession().ThreadStart(channelId, threadName, discordgo.ChannelTypeGuildText, archiveDuration)
 
 	if err != nil {
 		logger.DefaultLogger.Error(fmt.Sprintf("创建线程时异常 %s", err.Error()))
@@ -354,41 +460,16 @@ func (b *DiscordBot) ThreadStart(channelId, threadName string, archiveDuration i
 	return th.ID, nil
 }
 
+// NewProxyClient 创建使用指定代理的 http.Client, 支持带用户名密码的 http 与 socks5 代理
 func NewProxyClient(proxyUrl string) (proxyParse *url.URL, client *http.Client, err error) {
-	proxyParse, err = url.Parse(proxyUrl)
+	config := DefaultTransportConfig
+	config.ProxyURL = proxyUrl
+	t, err := newTransport(config)
 	if err != nil {
-		logger.DefaultLogger.Fatal("代理地址设置有误")
+		return nil, nil, err
 	}
-
-	if strings.HasPrefix(proxyParse.Scheme, "http") {
-		httpTransport := &http.Transport{
-			Proxy: http.ProxyURL(proxyParse),
-		}
-		return proxyParse, &http.Client{
-			Transport: httpTransport,
-		}, nil
-	} else if strings.HasPrefix(proxyParse.Scheme, "sock") {
-		dialer, err := proxy.SOCKS5("tcp", proxyParse.Host, nil, proxy.Direct)
-		if err != nil {
-			log.Fatal("Error creating dialer, ", err)
-		}
-
-		dialContext := func(ctx context.Context, network, addr string) (net.Conn, error) {
-			return dialer.Dial(network, addr)
-		}
-
-		// 使用该拨号器创建一个 HTTP 客户端
-		httpClient := &http.Client{
-			Transport: &http.Transport{
-				DialContext: dialContext,
-			},
-		}
-
-		return proxyParse, httpClient, nil
-	} else {
-		return nil, nil, fmt.Errorf("仅支持sock和http代理！")
-	}
-
+	proxyParse, _ = url.Parse(proxyUrl)
+	return proxyParse, t.client, nil
 }
 
 func (b *DiscordBot) stayActiveMessageTask() {
@@ -409,9 +490,14 @@ func (b *DiscordBot) stayActiveMessageTask() {
 		delay := next.Sub(now)
 
 		// 等待直到下一个间隔
-		time.Sleep(delay + time.Duration(randomNumber)*time.Second)
+		if !b.life.sleep(delay + time.Duration(randomNumber)*time.Second) {
+			return
+		}
+		if b.life.acquire() != nil {
+			return
+		}
 
-		var taskBotConfigs = BotConfigList
+		var taskBotConfigs = b.BotConfigs()
 
 		taskBotConfigs = append(taskBotConfigs, BotConfig{
 			ChannelId: b.defaultchannel,
@@ -420,7 +506,8 @@ func (b *DiscordBot) stayActiveMessageTask() {
 
 		taskBotConfigs = FilterUniqueBotChannel(taskBotConfigs)
 
-		logger.DefaultLogger.Info("CDP Scheduled Task Job Start!")
+		ctx := ensureRequestId(context.Background())
+		ctxLogger(ctx).Info("CDP Scheduled Task Job Start!")
 		var sendChannelList []string
 		for _, config := range taskBotConfigs {
 			var sendChannelId string
@@ -429,7 +516,7 @@ func (b *DiscordBot) stayActiveMessageTask() {
 				nextID, _ := NextID()
 				sendChannelId, err = b.CreateChannelWithRetry(b.guildID, fmt.Sprintf("cdp-chat-%s", nextID), 0)
 				if err != nil {
-					logger.DefaultLogger.Error(err.Error())
+					ctxLogger(ctx).Error("create channel failed", botField(config.BotId), zap.Error(err))
 					break
 				}
 				sendChannelList = append(sendChannelList, sendChannelId)
@@ -438,21 +525,22 @@ func (b *DiscordBot) stayActiveMessageTask() {
 			}
 			nextID, err := NextID()
 			if err != nil {
-				logger.DefaultLogger.Error(fmt.Sprintf("ChannelId{%s} BotId{%s} 活跃机器人任务消息发送异常!雪花Id生成失败!", sendChannelId, config.BotId))
+				ctxLogger(ctx).Error("活跃机器人任务消息发送异常!雪花Id生成失败!", channelField(sendChannelId), botField(config.BotId))
 				continue
 			}
-			_, _, _, err = b.SendMessageSpec(sendChannelId, config.BotId, fmt.Sprintf("【%v】 %s", nextID, "CDP Scheduled Task Job Send Msg Success!"))
+			_, _, _, err = b.SendMessageSpecContext(ctx, sendChannelId, config.BotId, fmt.Sprintf("【%v】 %s", nextID, "CDP Scheduled Task Job Send Msg Success!"))
 			if err != nil {
-				logger.DefaultLogger.Error(fmt.Sprintf("ChannelId{%s} BotId{%s} 活跃机器人任务消息发送异常!", sendChannelId, config.BotId))
+				ctxLogger(ctx).Error("活跃机器人任务消息发送异常!", channelField(sendChannelId), botField(config.BotId), zap.Error(err))
 			} else {
-				logger.DefaultLogger.Info(fmt.Sprintf("ChannelId{%s} BotId{%s} 活跃机器人任务消息发送成功!", sendChannelId, config.BotId))
+				ctxLogger(ctx).Info("活跃机器人任务消息发送成功!", channelField(sendChannelId), botField(config.BotId))
 			}
 			time.Sleep(5 * time.Second)
 		}
 		for _, channelId := range sendChannelList {
 			b.ChannelDel(channelId)
 		}
-		logger.DefaultLogger.Info("CDP Scheduled Task Job End!")
+		b.life.release()
+		ctxLogger(ctx).Info("CDP Scheduled Task Job End!")
 	}
 }
 
@@ -462,39 +550,18 @@ func getTimeString() string {
 }
 
 func (b *DiscordBot) UploadToDiscordAndGetURL(channelID string, base64Data string) (string, error) {
-
-	// 获取";base64,"后的Base64编码部分
-	dataParts := strings.Split(base64Data, ";base64,")
-	if len(dataParts) != 2 {
-		return "", fmt.Errorf("")
-	}
-	base64Data = dataParts[1]
-
-	data, err := base64.StdEncoding.DecodeString(base64Data)
+	file, err := dataURLFile(base64Data)
 	if err != nil {
 		return "", err
 	}
-	// 创建一个新的文件读取器
-	file := bytes.NewReader(data)
-
-	kind, err := filetype.Match(data)
-
-	if err != nil {
-		return "", fmt.Errorf("无法识别的文件类型")
-	}
 
 	// 创建一个新的 MessageSend 结构
 	m := &discordgo.MessageSend{
-		Files: []*discordgo.File{
-			{
-				Name:   fmt.Sprintf("file-%s.%s", getTimeString(), kind.Extension),
-				Reader: file,
-			},
-		},
+		Files: []*discordgo.File{file},
 	}
 
 	// 发送消息
-	message, err := b.session.ChannelMessageSendComplex(channelID, m)
+	message, err := b.getSession().ChannelMessageSendComplex(channelID, m)
 	if err != nil {
 		return "", err
 	}
@@ -507,6 +574,35 @@ func (b *DiscordBot) UploadToDiscordAndGetURL(channelID string, base64Data strin
 	return "", fmt.Errorf("no attachment found in the message")
 }
 
+// dataURLFile 将 data:xxx;base64,... 形式的数据转换为待上传的附件, 文件类型根据内容识别
+func dataURLFile(base64Data string) (*discordgo.File, error) {
+	// 获取";base64,"后的Base64编码部分
+	dataParts := strings.Split(base64Data, ";base64,")
+	if len(dataParts) != 2 {
+		return nil, fmt.Errorf("")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(dataParts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	kind, err := filetype.Match(data)
+	if err != nil {
+		return nil, fmt.Errorf("无法识别的文件类型")
+	}
+	return newAttachmentFile(fmt.Sprintf("file-%s.%s", getTimeString(), kind.Extension), kind.MIME.Value, data), nil
+}
+
+// newAttachmentFile 待上传的附件, 由 discordgo 以 multipart 方式上传
+func newAttachmentFile(name, contentType string, data []byte) *discordgo.File {
+	return &discordgo.File{
+		Name:        name,
+		ContentType: contentType,
+		Reader:      bytes.NewReader(data),
+	}
+}
+
 // FilterConfigs 根据proxySecret和channelId过滤BotConfig
 func FilterConfigs(configs []BotConfig, secret, gptModel string, channelId *string) []BotConfig {
 	var filteredConfigs []BotConfig
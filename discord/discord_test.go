@@ -11,10 +11,11 @@ import (
 
 var testDiscordBot *DiscordBot
 
+// TestMain 存在 testdata/.env 时连接discord以运行联调测试, 否则只运行单元测试
 func TestMain(m *testing.M) {
 	if err := godotenv.Load("testdata/.env"); err != nil {
-		log.Fatal(err)
-		return
+		log.Printf("testdata/.env not loaded, skipping live discord tests: %v", err)
+		os.Exit(m.Run())
 	}
 
 	testDiscordBot = NewDiscordBot(os.Getenv("USER_AUTHORIZATION"),
@@ -26,7 +27,8 @@ func TestMain(m *testing.M) {
 	)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	go testDiscordBot.StartBot(ctx)
-	<-testDiscordBot.started
+	if err := testDiscordBot.Start(ctx); err != nil {
+		log.Fatal(err)
+	}
 	os.Exit(m.Run())
 }
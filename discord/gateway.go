@@ -0,0 +1,227 @@
+package discord
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/wwqdrh/gokit/logger"
+	"go.uber.org/zap"
+)
+
+// 重连退避参数
+var (
+	ReconnectBaseDelay = 1 * time.Second
+	ReconnectMaxDelay  = 2 * time.Minute
+	// ReconnectAlertThreshold 连续重连失败达到该次数后发出告警
+	ReconnectAlertThreshold = 5
+)
+
+// GatewayReconnectFailChan 连续重连失败告警, 无人接收时丢弃
+var GatewayReconnectFailChan = make(chan string)
+
+// ErrGatewayDisconnected gateway断开期间发起或等待中的请求返回该错误
+var ErrGatewayDisconnected = errors.New("discord gateway disconnected")
+
+type GatewayState string
+
+const (
+	GatewayConnecting   GatewayState = "connecting"
+	GatewayConnected    GatewayState = "connected"
+	GatewayDisconnected GatewayState = "disconnected"
+	GatewayClosed       GatewayState = "closed"
+)
+
+// GatewayHealth gateway连接状态快照
+type GatewayHealth struct {
+	State             GatewayState `json:"state"`
+	Since             time.Time    `json:"since"`
+	LastReady         time.Time    `json:"lastReady"`
+	ReconnectAttempts int          `json:"reconnectAttempts"`
+	LastError         string       `json:"lastError,omitempty"`
+}
+
+// gatewaySupervisor 跟踪 Ready/Resumed/Disconnect 事件并负责断线重连
+type gatewaySupervisor struct {
+	mu           sync.Mutex
+	health       GatewayHealth
+	down         chan struct{} // 断开时关闭, 恢复连接后替换为新的channel
+	reconnecting bool
+	disconnects  int // Disconnect 事件计数, 重连成功后据此判断期间是否再次断开
+}
+
+func newGatewaySupervisor() *gatewaySupervisor {
+	return &gatewaySupervisor{
+		health: GatewayHealth{State: GatewayConnecting, Since: time.Now()},
+		down:   make(chan struct{}),
+	}
+}
+
+func (g *gatewaySupervisor) snapshot() GatewayHealth {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.health
+}
+
+func (g *gatewaySupervisor) connected() bool {
+	return g.snapshot().State == GatewayConnected
+}
+
+// unavailable 连接已断开或已关闭
+func (g *gatewaySupervisor) unavailable() bool {
+	state := g.snapshot().State
+	return state == GatewayDisconnected || state == GatewayClosed
+}
+
+// lost 返回当前连接断开时会被关闭的channel
+func (g *gatewaySupervisor) lost() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.down
+}
+
+func (g *gatewaySupervisor) setState(state GatewayState) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.setStateLocked(state)
+}
+
+func (g *gatewaySupervisor) setStateLocked(state GatewayState) {
+	if g.health.State == state {
+		return
+	}
+	switch state {
+	case GatewayConnected:
+		if g.health.State == GatewayDisconnected || g.health.State == GatewayClosed {
+			g.down = make(chan struct{})
+		}
+		g.health.LastReady = time.Now()
+		g.health.ReconnectAttempts = 0
+		g.health.LastError = ""
+	case GatewayDisconnected, GatewayClosed:
+		if g.health.State == GatewayConnected || g.health.State == GatewayConnecting {
+			close(g.down)
+		}
+	}
+	g.health.State = state
+	g.health.Since = time.Now()
+}
+
+// GatewayHealth 返回当前gateway连接状态
+func (b *DiscordBot) GatewayHealth() GatewayHealth {
+	return b.gateway.snapshot()
+}
+
+func (b *DiscordBot) onReady(s *discordgo.Session, r *discordgo.Ready) {
+	b.gateway.setState(GatewayConnected)
+	logger.DefaultLogger.Info("discord gateway ready", zap.String("session_id", r.SessionID))
+}
+
+func (b *DiscordBot) onResumed(s *discordgo.Session, r *discordgo.Resumed) {
+	b.gateway.setState(GatewayConnected)
+	logger.DefaultLogger.Info("discord gateway resumed")
+}
+
+func (b *DiscordBot) onDisconnect(s *discordgo.Session, d *discordgo.Disconnect) {
+	select {
+	case <-b.life.done:
+		b.gateway.setState(GatewayClosed)
+		return
+	default:
+	}
+
+	if !b.gateway.disconnect() {
+		return
+	}
+	logger.DefaultLogger.Warn("discord gateway disconnected, reconnecting")
+	go b.reconnectLoop()
+}
+
+// disconnect 记录一次断开, 返回是否需要启动重连. 主动关闭或已在重连中时返回false
+func (g *gatewaySupervisor) disconnect() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.health.State == GatewayClosed {
+		// 主动关闭, 不重连
+		return false
+	}
+	g.disconnects++
+	g.setStateLocked(GatewayDisconnected)
+	if g.reconnecting {
+		return false
+	}
+	g.reconnecting = true
+	return true
+}
+
+// reconnectSeq 当前的断开计数, 在尝试重连之前读取
+func (g *gatewaySupervisor) reconnectSeq() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.disconnects
+}
+
+// endReconnect 自 seen 以来没有新的断开时结束重连并返回true; 期间再次断开的事件因重连中被忽略, 返回false继续重连
+func (g *gatewaySupervisor) endReconnect(seen int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.disconnects != seen {
+		return false
+	}
+	g.reconnecting = false
+	return true
+}
+
+// reconnectLoop 以指数退避重连gateway, 连续失败达到阈值时告警
+func (b *DiscordBot) reconnectLoop() {
+	g := b.gateway
+	delay := ReconnectBaseDelay
+	for {
+		if !b.life.sleep(delay) {
+			g.mu.Lock()
+			g.reconnecting = false
+			g.mu.Unlock()
+			return
+		}
+		seen := g.reconnectSeq()
+		if g.connected() {
+			if g.endReconnect(seen) {
+				return
+			}
+			continue
+		}
+
+		err := b.getSession().Open()
+		if err == nil || errors.Is(err, discordgo.ErrWSAlreadyOpen) {
+			if g.endReconnect(seen) {
+				logger.DefaultLogger.Info("discord gateway reconnected")
+				return
+			}
+			// 连接成功后立即再次断开, 从头开始退避
+			logger.DefaultLogger.Warn("discord gateway disconnected again while reconnecting")
+			delay = ReconnectBaseDelay
+			continue
+		}
+
+		g.mu.Lock()
+		g.health.ReconnectAttempts++
+		g.health.LastError = err.Error()
+		attempts := g.health.ReconnectAttempts
+		g.mu.Unlock()
+
+		logger.DefaultLogger.Error("discord gateway reconnect failed", zap.Int("attempts", attempts), zap.Error(err))
+		if attempts%ReconnectAlertThreshold == 0 {
+			select {
+			case GatewayReconnectFailChan <- fmt.Sprintf("discord gateway 已连续重连失败 %d 次: %s", attempts, err):
+			default:
+			}
+		}
+
+		delay *= 2
+		if delay > ReconnectMaxDelay {
+			delay = ReconnectMaxDelay
+		}
+	}
+}
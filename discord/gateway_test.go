@@ -0,0 +1,31 @@
+package discord
+
+import "testing"
+
+func TestGatewayDisconnectDuringReconnect(t *testing.T) {
+	g := newGatewaySupervisor()
+	g.setState(GatewayConnected)
+
+	if !g.disconnect() {
+		t.Fatal("first disconnect should start reconnecting")
+	}
+	seen := g.reconnectSeq()
+	// Open 成功后、结束重连之前连接再次断开, 事件因重连中被忽略
+	if g.disconnect() {
+		t.Fatal("disconnect while reconnecting should not start another loop")
+	}
+	if g.endReconnect(seen) {
+		t.Fatal("reconnect should continue after a disconnect it did not observe")
+	}
+	if !g.endReconnect(g.reconnectSeq()) {
+		t.Fatal("reconnect should end when no new disconnect arrived")
+	}
+	if !g.disconnect() {
+		t.Fatal("disconnect after reconnect ended should start reconnecting again")
+	}
+
+	g.setState(GatewayClosed)
+	if g.disconnect() {
+		t.Fatal("closed gateway should not reconnect")
+	}
+}
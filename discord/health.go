@@ -0,0 +1,137 @@
+package discord
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// MaxGuildChannels discord 单个服务器的频道数量上限
+var MaxGuildChannels = 500
+
+// GuildChannelsCacheTTL session缓存中没有服务器信息时, 通过接口获取的频道列表的缓存时长, 避免探针频繁请求discord
+var GuildChannelsCacheTTL = 30 * time.Second
+
+// guildChannelsCache 通过接口获取的频道列表, 请求失败的结果同样缓存
+type guildChannelsCache struct {
+	mu        sync.Mutex
+	channels  []*discordgo.Channel
+	ok        bool
+	fetchedAt time.Time
+}
+
+// HealthReport 健康检查结果
+type HealthReport struct {
+	Live            bool          `json:"live"`
+	Ready           bool          `json:"ready"`
+	Gateway         GatewayHealth `json:"gateway"`
+	SessionOpen     bool          `json:"sessionOpen"`
+	UsableAuths     int           `json:"usableAuths"`
+	ConfigErrors    []string      `json:"configErrors,omitempty"`
+	TempChannels    int           `json:"tempChannels"`
+	GuildChannels   int           `json:"guildChannels"`
+	GuildChannelCap int           `json:"guildChannelCap"`
+	Queue           *QueueStats   `json:"queue,omitempty"`
+	Problems        []string      `json:"problems,omitempty"`
+}
+
+// Health 汇总gateway会话、用户授权、配置校验以及服务器频道容量
+func (b *DiscordBot) Health() HealthReport {
+	report := HealthReport{
+		Gateway:         b.gateway.snapshot(),
+		UsableAuths:     len(b.auths.usable()),
+		GuildChannelCap: MaxGuildChannels,
+	}
+	report.SessionOpen = b.getSession() != nil && report.Gateway.State == GatewayConnected
+	if b.queue != nil {
+		stats := b.queue.stats()
+		report.Queue = &stats
+	}
+
+	b.life.mu.RLock()
+	closing := b.life.closing
+	b.life.mu.RUnlock()
+	// 启动中的服务同样存活, 是否可处理请求由 Ready 反映
+	report.Live = !closing
+
+	if problems := b.configProblems(); len(problems) > 0 {
+		report.ConfigErrors = problems
+		report.Problems = append(report.Problems, "configuration invalid")
+	}
+	if closing {
+		report.Problems = append(report.Problems, "bot is shutting down")
+	}
+	if !report.SessionOpen {
+		report.Problems = append(report.Problems, "discord session is not open")
+	}
+	if report.UsableAuths == 0 {
+		report.Problems = append(report.Problems, "no usable user authorization")
+	}
+
+	if channels, ok := b.guildChannels(); ok {
+		report.GuildChannels = len(channels)
+		for _, channel := range channels {
+			if strings.HasPrefix(channel.Name, "cdp-chat-") {
+				report.TempChannels++
+			}
+		}
+		// 频道已满且无法腾出临时频道时无法创建对话频道
+		if report.GuildChannels >= report.GuildChannelCap &&
+			(b.maxChannelDelType == "" || report.TempChannels == 0) {
+			report.Problems = append(report.Problems, "guild channel limit reached")
+		}
+	}
+
+	report.Ready = len(report.Problems) == 0
+	return report
+}
+
+// guildChannels 优先从session缓存读取服务器频道列表
+func (b *DiscordBot) guildChannels() ([]*discordgo.Channel, bool) {
+	session := b.getSession()
+	if session == nil || b.guildID == "" {
+		return nil, false
+	}
+	if guild, err := session.State.Guild(b.guildID); err == nil {
+		return guild.Channels, true
+	}
+	cache := &b.guildChannelsCache
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if !cache.fetchedAt.IsZero() && time.Since(cache.fetchedAt) < GuildChannelsCacheTTL {
+		return cache.channels, cache.ok
+	}
+	channels, err := session.GuildChannels(b.guildID)
+	cache.channels, cache.ok, cache.fetchedAt = channels, err == nil, time.Now()
+	return cache.channels, cache.ok
+}
+
+// HealthzHandler 存活探针, 服务关闭中或已关闭时返回503
+func (b *DiscordBot) HealthzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := b.Health()
+		writeHealth(w, report, report.Live)
+	}
+}
+
+// ReadyzHandler 就绪探针, 无法处理请求时返回503
+func (b *DiscordBot) ReadyzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := b.Health()
+		writeHealth(w, report, report.Ready)
+	}
+}
+
+func writeHealth(w http.ResponseWriter, report HealthReport, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
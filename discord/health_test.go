@@ -0,0 +1,20 @@
+package discord
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHealthLiveUntilShutdown(t *testing.T) {
+	b := NewDiscordBot("")
+	// 启动前存活但未就绪
+	if report := b.Health(); !report.Live || report.Ready {
+		t.Fatalf("before start: live=%v ready=%v, want live and not ready", report.Live, report.Ready)
+	}
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if report := b.Health(); report.Live {
+		t.Fatal("still live after shutdown")
+	}
+}
@@ -0,0 +1,181 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/wwqdrh/gokit/logger"
+	"go.uber.org/zap"
+)
+
+// ShutdownTimeout StartBot 在ctx取消后等待进行中请求的最长时间
+var ShutdownTimeout = 30 * time.Second
+
+// ErrBotClosed 服务正在关闭或已关闭时返回
+var ErrBotClosed = errors.New("discord bot is shutting down")
+
+// ErrBotStarted 重复调用 Start 时返回
+var ErrBotStarted = errors.New("discord bot already started")
+
+// lifecycle 记录进行中的请求与临时频道, 用于优雅退出
+type lifecycle struct {
+	mu       sync.RWMutex
+	starting bool // Start 已调用且未失败
+	closing  bool
+	inflight sync.WaitGroup
+
+	doneOnce  sync.Once
+	done      chan struct{} // 停止接收新请求与定时任务
+	abortOnce sync.Once
+	abort     chan struct{} // 等待超时, 通知仍在进行中的请求放弃等待
+	stopOnce  sync.Once
+	stopped   chan struct{} // Shutdown 执行完毕
+
+	tempChannels  sync.Map // map[string]struct{} 由本服务创建且尚未删除的 cdp-chat- 频道
+	channelTimers sync.Map // map[string]*time.Timer 频道ID -> 本服务设置的删除定时器
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{
+		done:    make(chan struct{}),
+		abort:   make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// acquire 登记一个进行中的请求, 关闭后返回 ErrBotClosed
+func (l *lifecycle) acquire() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closing {
+		return ErrBotClosed
+	}
+	l.inflight.Add(1)
+	return nil
+}
+
+func (l *lifecycle) release() {
+	l.inflight.Done()
+}
+
+// beginStart 标记开始启动, 已启动时返回 ErrBotStarted, 关闭后返回 ErrBotClosed
+func (l *lifecycle) beginStart() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closing {
+		return ErrBotClosed
+	}
+	if l.starting {
+		return ErrBotStarted
+	}
+	l.starting = true
+	return nil
+}
+
+// abortStart 启动失败后允许再次调用 Start
+func (l *lifecycle) abortStart() {
+	l.mu.Lock()
+	l.starting = false
+	l.mu.Unlock()
+}
+
+// sleep 等待d, 若期间开始关闭则返回false
+func (l *lifecycle) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+func (b *DiscordBot) trackTempChannel(channelId, channelName string) {
+	if strings.HasPrefix(channelName, "cdp-chat-") {
+		b.life.tempChannels.Store(channelId, struct{}{})
+	}
+}
+
+// getSession Start 创建的 discord session, 启动前或启动失败后为nil
+func (b *DiscordBot) getSession() *discordgo.Session {
+	return b.session.Load()
+}
+
+// sessionRunning discord session 已由 Start 创建且服务未在关闭中
+func (b *DiscordBot) sessionRunning() bool {
+	b.life.mu.RLock()
+	defer b.life.mu.RUnlock()
+	return b.getSession() != nil && !b.life.closing
+}
+
+// isTempChannel 频道是否为本服务创建且尚未删除的临时频道
+func (b *DiscordBot) isTempChannel(channelId string) bool {
+	_, ok := b.life.tempChannels.Load(channelId)
+	return ok
+}
+
+func (b *DiscordBot) untrackTempChannel(channelId string) {
+	b.life.tempChannels.Delete(channelId)
+}
+
+// Shutdown 停止接收新请求, 在ctx截止前等待进行中的回复, 随后停止频道删除定时器、
+// 删除本服务创建的临时频道并关闭discord连接. 信号处理交由调用方负责.
+func (b *DiscordBot) Shutdown(ctx context.Context) error {
+	l := b.life
+	l.mu.Lock()
+	l.closing = true
+	l.mu.Unlock()
+	l.doneOnce.Do(func() { close(l.done) })
+
+	drained := make(chan struct{})
+	go func() {
+		l.inflight.Wait()
+		close(drained)
+	}()
+
+	var errs []error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		l.abortOnce.Do(func() { close(l.abort) })
+		logger.DefaultLogger.Warn("shutdown deadline exceeded, abandoning in-flight requests")
+		errs = append(errs, fmt.Errorf("wait in-flight requests: %w", ctx.Err()))
+	}
+
+	l.channelTimers.Range(func(key, value any) bool {
+		value.(*time.Timer).Stop()
+		l.channelTimers.Delete(key)
+		// 定时删除的频道同样是临时频道, 一并清理
+		b.life.tempChannels.Store(key, struct{}{})
+		return true
+	})
+
+	if session := b.getSession(); session != nil {
+		l.tempChannels.Range(func(key, _ any) bool {
+			if _, err := b.ChannelDel(key.(string)); err != nil {
+				logger.DefaultLogger.Warn("delete temp channel failed", channelField(key.(string)), zap.Error(err))
+				errs = append(errs, err)
+			}
+			return true
+		})
+
+		b.gateway.setState(GatewayClosed)
+		if err := session.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close discord session: %w", err))
+		}
+	}
+
+	if err := b.usage.flush(); err != nil {
+		errs = append(errs, fmt.Errorf("flush usage ledger: %w", err))
+	}
+
+	l.stopOnce.Do(func() { close(l.stopped) })
+	logger.DefaultLogger.Info("Bot shutdown finished.")
+	return errors.Join(errs...)
+}
@@ -0,0 +1,59 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStartChecksConfigBeforeConnecting(t *testing.T) {
+	b := NewDiscordBot("")
+	for i := 0; i < 2; i++ {
+		// 校验失败不建立连接, 也不阻止修正配置后再次启动
+		var configErr *ConfigError
+		if err := b.Start(context.Background()); !errors.As(err, &configErr) {
+			t.Fatalf("start %d: err = %v, want *ConfigError", i, err)
+		}
+		if b.getSession() != nil {
+			t.Fatalf("start %d: session created despite invalid config", i)
+		}
+	}
+}
+
+func TestLifecycleStartOnce(t *testing.T) {
+	l := newLifecycle()
+	if err := l.beginStart(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.beginStart(); !errors.Is(err, ErrBotStarted) {
+		t.Fatalf("second start err = %v, want ErrBotStarted", err)
+	}
+	l.abortStart()
+	if err := l.beginStart(); err != nil {
+		t.Fatalf("start after failed start: %v", err)
+	}
+
+	l.closing = true
+	l.starting = false
+	if err := l.beginStart(); !errors.Is(err, ErrBotClosed) {
+		t.Fatalf("start after shutdown err = %v, want ErrBotClosed", err)
+	}
+}
+
+func TestShutdownKeepsOtherBotsTimers(t *testing.T) {
+	a, b := NewDiscordBot(""), NewDiscordBot("")
+	timer := time.AfterFunc(time.Hour, func() {})
+	defer timer.Stop()
+	a.life.channelTimers.Store("123", timer)
+
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.life.channelTimers.Load("123"); !ok {
+		t.Fatal("shutting down one bot removed another bot's delete timer")
+	}
+	if !timer.Stop() {
+		t.Fatal("shutting down one bot stopped another bot's delete timer")
+	}
+}
@@ -0,0 +1,68 @@
+package discord
+
+import (
+	"context"
+	"strings"
+
+	"github.com/wwqdrh/gokit/logger"
+	"go.uber.org/zap"
+)
+
+type ctxKey string
+
+// ContextWithRequestId 将请求id(X-Request-Id)写入context, 之后该ctx上的日志都会带上request_id字段
+func ContextWithRequestId(ctx context.Context, requestId string) context.Context {
+	ctx = context.WithValue(ctx, ctxKey(RequestIdKey), requestId)
+	return withLogFields(ctx, zap.String("request_id", requestId))
+}
+
+// RequestIdFromContext 从context中读取请求id,不存在时返回空字符串
+func RequestIdFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(ctxKey(RequestIdKey)).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// ensureRequestId 若ctx中没有请求id则生成一个
+func ensureRequestId(ctx context.Context) context.Context {
+	if RequestIdFromContext(ctx) != "" {
+		return ctx
+	}
+	id, err := NextID()
+	if err != nil {
+		id = getTimeString() + GetRandomString(8)
+	}
+	return ContextWithRequestId(ctx, id)
+}
+
+// ctxLogger 返回ctx上携带的logger, 没有则返回默认logger
+func ctxLogger(ctx context.Context) *logger.ZapX {
+	return logger.DefaultLogger.WithContext(ctx)
+}
+
+// withLogFields 在ctx的logger上追加结构化字段
+func withLogFields(ctx context.Context, fields ...zap.Field) context.Context {
+	ctx, _ = ctxLogger(ctx).AddCtx(ctx, fields...)
+	return ctx
+}
+
+// MaskSecret 脱敏token等敏感信息, 仅保留首尾各4位
+func MaskSecret(secret string) string {
+	if len(secret) <= 8 {
+		return strings.Repeat("*", len(secret))
+	}
+	return secret[:4] + "****" + secret[len(secret)-4:]
+}
+
+func authField(userAuth string) zap.Field {
+	return zap.String("auth", MaskSecret(userAuth))
+}
+
+func channelField(channelId string) zap.Field {
+	return zap.String("channel_id", channelId)
+}
+
+func botField(botId string) zap.Field {
+	return zap.String("bot_id", botId)
+}
@@ -2,12 +2,11 @@ package discord
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-	"io"
 	"math/rand"
 	"net/http"
-	"net/url"
 	"strings"
 
 	"fmt"
@@ -16,6 +15,7 @@ import (
 	"github.com/bwmarrin/discordgo"
 	"github.com/wwqdrh/gobot/types"
 	"github.com/wwqdrh/gokit/logger"
+	"go.uber.org/zap"
 )
 
 var RateLimitKeyExpirationDuration = 20 * time.Minute
@@ -28,6 +28,7 @@ var CreateChannelRiskChan = make(chan string)
 var NoAvailableUserAuthPreNotifyTime time.Time
 var CreateChannelRiskPreNotifyTime time.Time
 
+// BotConfigList 启动时的初始 BotConfig, 运行时通过管理接口维护, 以 DiscordBot.BotConfigs 为准
 var BotConfigList []BotConfig
 
 type ReplyResp struct {
@@ -79,57 +80,58 @@ func (b *DiscordBot) loadUserAuthTask() {
 		delay := next.Sub(now)
 
 		// 等待直到下一个间隔
-		time.Sleep(delay + time.Duration(randomNumber)*time.Second)
+		if !b.life.sleep(delay + time.Duration(randomNumber)*time.Second) {
+			return
+		}
 
 		logger.DefaultLogger.Info("CDP Scheduled loadUserAuth Task Job Start!")
-		b.authorizations = strings.Split(b.authorization, ",")
-		logger.DefaultLogger.Info(fmt.Sprintf("UserAuths: %+v", b.authorizations))
+		b.auths.resetSuspended()
+		logger.DefaultLogger.Info("UserAuths reloaded", zap.Int("usable", len(b.auths.usable())))
 		logger.DefaultLogger.Info("CDP Scheduled loadUserAuth Task Job  End!")
 	}
 }
 
 // messageCreate handles the create messages in Discord.
 func (b *DiscordBot) messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
-	// 提前检查参考消息是否为 nil
-	if m.ReferencedMessage == nil {
+	// 关联到等待中的请求, 无关的消息直接忽略
+	c, ok := b.replies.resolve(m.Message)
+	if !ok {
 		return
 	}
 
 	// 尝试获取 stopChan
-	stopChan, exists := b.replyStopChans.Load(m.ReferencedMessage.ID)
+	stopChan, exists := b.replyStopChans.Load(c.key)
 	if !exists {
-		//channel, myerr := Session.Channel(m.ChannelID)
-		// 不存在则直接删除频道
-		//if myerr != nil || strings.HasPrefix(channel.Name, "cdp-chat-") {
-		//SetChannelDeleteTimer(m.ChannelID, 5*time.Minute)
 		return
-		//}
 	}
 
+	pending := b.pendingReply(c.key)
+	ctx, span := startSpan(pending.ctx, "discord.messageCreate",
+		AttrChannelId.String(m.ChannelID), AttrMessageId.String(m.ID))
+	defer span.End()
+
 	// 如果作者为 nil 或消息来自 bot 本身,则发送停止信号
 	if m.Author == nil || m.Author.ID == s.State.User.ID {
 		//SetChannelDeleteTimer(m.ChannelID, 5*time.Minute)
-		stopChan.(chan ChannelStopChan) <- ChannelStopChan{
-			Id: m.ChannelID,
-		}
+		deliver(stopChan.(chan ChannelStopChan), ChannelStopChan{Id: m.ChannelID}, c.done)
 		return
 	}
 
-	replyChan, exists := b.repliesChans.Load(m.ReferencedMessage.ID)
+	replyChan, exists := b.repliesChans.Load(c.key)
 	if exists {
 		reply := processMessageCreate(m)
-		replyChan.(chan ReplyResp) <- reply
+		deliver(replyChan.(chan ReplyResp), reply, c.done)
 	} else {
-		logger.DefaultLogger.Debug(m.ReferencedMessage.ID)
-		replyOpenAIChan, exists := b.repliesOpenAIChans.Load(m.ReferencedMessage.ID)
+		ctxLogger(ctx).Debug("reply received", zap.String("request_message_id", c.key))
+		replyOpenAIChan, exists := b.repliesOpenAIChans.Load(c.key)
 		if exists {
-			reply := res2OpenAI(m)
-			replyOpenAIChan.(chan types.OpenAIChatCompletionResponse) <- reply
+			reply := res2OpenAI(m, pending)
+			deliver(replyOpenAIChan.(chan replyUpdate), replyUpdate{resp: reply, message: m.Message}, c.done)
 		} else {
-			replyOpenAIImageChan, exists := b.repliesOpenAIImageChans.Load(m.ReferencedMessage.ID)
+			replyOpenAIImageChan, exists := b.repliesOpenAIImageChans.Load(c.key)
 			if exists {
 				reply := processMessageCreateForOpenAIImage(m)
-				replyOpenAIImageChan.(chan types.OpenAIImagesGenerationResponse) <- reply
+				deliver(replyOpenAIImageChan.(chan types.OpenAIImagesGenerationResponse), reply, c.done)
 			} else {
 				return
 			}
@@ -140,75 +142,67 @@ func (b *DiscordBot) messageCreate(s *discordgo.Session, m *discordgo.MessageCre
 
 	// 如果消息包含组件或嵌入,则发送停止信号
 	if len(m.Message.Components) > 0 {
-		var suggestions []string
-
-		actionRow, _ := m.Message.Components[0].(*discordgo.ActionsRow)
-		for _, component := range actionRow.Components {
-			button := component.(*discordgo.Button)
-			suggestions = append(suggestions, button.Label)
-		}
+		suggestions := parseSuggestions(m.Message.Components)
 
-		replyOpenAIChan, exists := b.repliesOpenAIChans.Load(m.ReferencedMessage.ID)
+		replyOpenAIChan, exists := b.repliesOpenAIChans.Load(c.key)
 		if exists {
-			reply := res2OpenAI(m)
+			reply := res2OpenAI(m, pending)
 			stopStr := "stop"
 			reply.Choices[0].FinishReason = &stopStr
 			reply.Suggestions = suggestions
-			replyOpenAIChan.(chan types.OpenAIChatCompletionResponse) <- reply
+			deliver(replyOpenAIChan.(chan replyUpdate), replyUpdate{resp: reply, message: m.Message}, c.done)
 		}
 
-		replyOpenAIImageChan, exists := b.repliesOpenAIImageChans.Load(m.ReferencedMessage.ID)
+		replyOpenAIImageChan, exists := b.repliesOpenAIImageChans.Load(c.key)
 		if exists {
 			reply := processMessageCreateForOpenAIImage(m)
 			reply.Suggestions = suggestions
-			replyOpenAIImageChan.(chan types.OpenAIImagesGenerationResponse) <- reply
+			deliver(replyOpenAIImageChan.(chan types.OpenAIImagesGenerationResponse), reply, c.done)
 		}
 
-		stopChan.(chan ChannelStopChan) <- ChannelStopChan{
-			Id: m.ChannelID,
-		}
+		deliver(stopChan.(chan ChannelStopChan), ChannelStopChan{Id: m.ChannelID}, c.done)
 	}
 }
 
 // messageUpdate handles the updated messages in Discord.
 func (b *DiscordBot) messageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) {
-	// 提前检查参考消息是否为 nil
-	if m.ReferencedMessage == nil {
+	// 关联到等待中的请求, 无关的消息直接忽略
+	c, ok := b.replies.resolve(m.Message)
+	if !ok {
 		return
 	}
 
 	// 尝试获取 stopChan
-	stopChan, exists := b.replyStopChans.Load(m.ReferencedMessage.ID)
+	stopChan, exists := b.replyStopChans.Load(c.key)
 	if !exists {
-		channel, err := b.session.Channel(m.ChannelID)
-		// 不存在则直接删除频道
-		if err != nil || strings.HasPrefix(channel.Name, "cdp-chat-") {
-			return
-		}
+		return
 	}
 
+	pending := b.pendingReply(c.key)
+	_, span := startSpan(pending.ctx, "discord.messageUpdate",
+		AttrChannelId.String(m.ChannelID), AttrMessageId.String(m.ID))
+	defer span.End()
+
 	// 如果作者为 nil 或消息来自 bot 本身,则发送停止信号
 	if m.Author == nil || m.Author.ID == s.State.User.ID {
-		stopChan.(chan ChannelStopChan) <- ChannelStopChan{
-			Id: m.ChannelID,
-		}
+		deliver(stopChan.(chan ChannelStopChan), ChannelStopChan{Id: m.ChannelID}, c.done)
 		return
 	}
 
-	replyChan, exists := b.repliesChans.Load(m.ReferencedMessage.ID)
+	replyChan, exists := b.repliesChans.Load(c.key)
 	if exists {
 		reply := processMessageUpdate(m)
-		replyChan.(chan ReplyResp) <- reply
+		deliver(replyChan.(chan ReplyResp), reply, c.done)
 	} else {
-		replyOpenAIChan, exists := b.repliesOpenAIChans.Load(m.ReferencedMessage.ID)
+		replyOpenAIChan, exists := b.repliesOpenAIChans.Load(c.key)
 		if exists {
-			reply := processMessageUpdateForOpenAI(m)
-			replyOpenAIChan.(chan types.OpenAIChatCompletionResponse) <- reply
+			reply := processMessageUpdateForOpenAI(m, pending)
+			deliver(replyOpenAIChan.(chan replyUpdate), replyUpdate{resp: reply, message: m.Message}, c.done)
 		} else {
-			replyOpenAIImageChan, exists := b.repliesOpenAIImageChans.Load(m.ReferencedMessage.ID)
+			replyOpenAIImageChan, exists := b.repliesOpenAIImageChans.Load(c.key)
 			if exists {
 				reply := processMessageUpdateForOpenAIImage(m)
-				replyOpenAIImageChan.(chan types.OpenAIImagesGenerationResponse) <- reply
+				deliver(replyOpenAIImageChan.(chan types.OpenAIImagesGenerationResponse), reply, c.done)
 			} else {
 				return
 			}
@@ -219,237 +213,442 @@ func (b *DiscordBot) messageUpdate(s *discordgo.Session, m *discordgo.MessageUpd
 
 	// 如果消息包含组件或嵌入,则发送停止信号
 	if len(m.Message.Components) > 0 {
+		suggestions := parseSuggestions(m.Message.Components)
 
-		var suggestions []string
-
-		actionRow, _ := m.Message.Components[0].(*discordgo.ActionsRow)
-		for _, component := range actionRow.Components {
-			button := component.(*discordgo.Button)
-			suggestions = append(suggestions, button.Label)
-		}
-
-		replyOpenAIChan, exists := b.repliesOpenAIChans.Load(m.ReferencedMessage.ID)
+		replyOpenAIChan, exists := b.repliesOpenAIChans.Load(c.key)
 		if exists {
-			reply := processMessageUpdateForOpenAI(m)
+			reply := processMessageUpdateForOpenAI(m, pending)
 			stopStr := "stop"
 			reply.Choices[0].FinishReason = &stopStr
 			reply.Suggestions = suggestions
-			replyOpenAIChan.(chan types.OpenAIChatCompletionResponse) <- reply
+			deliver(replyOpenAIChan.(chan replyUpdate), replyUpdate{resp: reply, message: m.Message}, c.done)
 		}
 
-		replyOpenAIImageChan, exists := b.repliesOpenAIImageChans.Load(m.ReferencedMessage.ID)
+		replyOpenAIImageChan, exists := b.repliesOpenAIImageChans.Load(c.key)
 		if exists {
 			reply := processMessageUpdateForOpenAIImage(m)
 			reply.Suggestions = suggestions
-			replyOpenAIImageChan.(chan types.OpenAIImagesGenerationResponse) <- reply
+			deliver(replyOpenAIImageChan.(chan types.OpenAIImagesGenerationResponse), reply, c.done)
 		}
 
-		stopChan.(chan ChannelStopChan) <- ChannelStopChan{
-			Id: m.ChannelID,
-		}
+		deliver(stopChan.(chan ChannelStopChan), ChannelStopChan{Id: m.ChannelID}, c.done)
+	}
+}
+
+// pendingReply 等待回复的请求: 请求context用于关联gateway事件的span, model 为回复中的模型,
+// tokenModel 与 promptTokens 用于统计usage
+type pendingReply struct {
+	ctx          context.Context
+	model        string
+	tokenModel   string
+	promptTokens int
+}
+
+// pendingReply 返回消息对应的等待中请求, 不存在时返回空请求
+func (b *DiscordBot) pendingReply(messageId string) *pendingReply {
+	if pending, ok := b.pendingReplies.Load(messageId); ok {
+		return pending.(*pendingReply)
+	}
+	return &pendingReply{ctx: context.Background()}
+}
+
+// sendRequest 消息发送参数: 被@的bot与发送频道, channelId 为空时创建临时频道并在回复后删除.
+// model 为实际使用的模型, tokenModel 为统计token使用的模型(调用方请求的模型), 为空时使用 model;
+// promptTokens 为按原始请求消息统计的token数, 为0时按发送内容统计.
+// cacheKey 为响应缓存的key, 为空时见 responseCacheKey. completion 为回复完成的判定条件.
+// stream 不为空时回复过程中以增量方式输出合并后的内容
+type sendRequest struct {
+	botId        string
+	channelId    string
+	model        string
+	tokenModel   string
+	promptTokens int
+	cacheKey     string
+	completion   CompletionConfig
+	stream       *deltaStream
+	// onSent 每段消息发送成功后调用
+	onSent func(channelId, messageId string)
+}
+
+// countModel 统计token使用的模型
+func (req sendRequest) countModel() string {
+	if req.tokenModel != "" {
+		return req.tokenModel
+	}
+	return req.model
+}
+
+func (req sendRequest) sent(channelId, messageId string) {
+	if req.onSent != nil {
+		req.onSent(channelId, messageId)
+	}
+}
+
+// responseCacheKey 响应缓存的key. 未指定 cacheKey 时按bot、model与发送内容生成;
+// 指定频道的发送(SendTo、ClickSuggestion)的回复依赖频道中的对话上下文, 不使用缓存
+func (req sendRequest) responseCacheKey(message string) (string, bool) {
+	if req.cacheKey != "" {
+		return req.cacheKey, true
+	}
+	if req.channelId != "" {
+		return "", false
 	}
+	return ResponseCacheKey(req.botId+"/"+req.model, []types.OpenAIChatMessage{{Role: "user", Content: message}}), true
+}
+
+func (b *DiscordBot) defaultRequest() sendRequest {
+	return sendRequest{botId: b.botID, completion: b.completionConfig(nil)}
 }
 
 func (b *DiscordBot) SendPlain(message string) (string, error) {
-	msg, _, channelid, err := b.SendRaw(message)
-	defer b.ChannelDel(channelid)
+	return b.SendPlainContext(context.Background(), message)
+}
+
+// SendPlainContext 同 SendPlain, 并将ctx中的trace与请求id贯穿整个发送/回复流程
+func (b *DiscordBot) SendPlainContext(ctx context.Context, message string) (string, error) {
+	result, err := b.sendPlain(ctx, b.defaultRequest(), message)
 	if err != nil {
 		return "", err
 	}
-	logger.DefaultLogger.Debug(msg.ID)
-	replyChan := make(chan types.OpenAIChatCompletionResponse)
-	b.repliesOpenAIChans.Store(msg.ID, replyChan)
-	defer b.repliesOpenAIChans.Delete(msg.ID)
+	return result.Content, nil
+}
+
+// sendPlain 发送消息并等待回复, 开启响应缓存时命中缓存直接返回并标记 Cached, 缓存只保存回复内容
+func (b *DiscordBot) sendPlain(ctx context.Context, req sendRequest, message string) (result *SendResult, err error) {
+	ctx = withLogFields(ensureRequestId(ctx), botField(req.botId))
+	ctx, span := startSpan(ctx, "discord.SendPlain", AttrBotId.String(req.botId))
+	defer func() {
+		span.SetAttributes(AttrCacheHit.Bool(result != nil && result.Cached))
+		endSpan(span, err)
+	}()
+
+	cacheKey, cacheable := req.responseCacheKey(message)
+	useCache := b.cache != nil && !cacheBypassed(ctx) && cacheable
+	if useCache {
+		req.cacheKey = cacheKey
+		if content, ok := b.cache.get(ctx, req.cacheKey); ok {
+			ctxLogger(ctx).Debug("response cache hit")
+			return &SendResult{Content: content, BotId: req.botId, ChannelId: req.channelId, Cached: true}, nil
+		}
+	}
+
+	result, err = b.waitReply(ctx, req, message)
+	if err == nil && useCache && result.Content != "" && !SliceContains(CozeErrorMessages, result.Content) {
+		b.cache.set(ctx, req.cacheKey, result.Content)
+	}
+	return result, err
+}
+
+// waitReply 发送消息并等待bot回复完成
+func (b *DiscordBot) waitReply(ctx context.Context, req sendRequest, message string) (result *SendResult, err error) {
+	if err := b.life.acquire(); err != nil {
+		return nil, err
+	}
+	defer b.life.release()
+
+	if b.queue != nil {
+		release, err := b.queue.acquire(ctx)
+		if err != nil {
+			ctxLogger(ctx).Warn("request not admitted", zap.Error(err))
+			return nil, err
+		}
+		defer release()
+	}
+
+	// 发送前登记请求, 分段发送期间bot对已发送分段的回复同样能被关联
+	c := b.replies.register(req.botId)
+	defer b.replies.unregister(c.key)
+	req.onSent = func(channelId, messageId string) {
+		b.replies.addPrompt(c, channelId, messageId)
+	}
+	if req.promptTokens == 0 {
+		req.promptTokens, _ = CountMessageTokens(req.countModel(), []types.OpenAIChatMessage{{Role: "user", Content: message}})
+	}
+	b.pendingReplies.Store(c.key, &pendingReply{ctx: ctx, model: req.model, tokenModel: req.countModel(), promptTokens: req.promptTokens})
+	defer b.pendingReplies.Delete(c.key)
+
+	lost := b.gateway.lost()
+	replyChan := make(chan replyUpdate)
+	b.repliesOpenAIChans.Store(c.key, replyChan)
+	defer b.repliesOpenAIChans.Delete(c.key)
 
 	stopChan := make(chan ChannelStopChan)
-	b.replyStopChans.Store(msg.ID, stopChan)
-	defer b.replyStopChans.Delete(msg.ID)
+	b.replyStopChans.Store(c.key, stopChan)
+	defer b.replyStopChans.Delete(c.key)
 
+	// 临时频道在回复后删除; 回复带有建议时延迟 SuggestionChannelTTL 删除, 以便通过 ClickSuggestion 继续对话
+	temp := req.channelId == "" || b.isTempChannel(req.channelId)
+	if req.channelId != "" && temp {
+		b.CancelChannelDeleteTimer(req.channelId)
+	}
+	sentIds, userAuth, channelid, err := b.sendRaw(ctx, req, message)
+	if temp {
+		defer func() {
+			if channelid == "" {
+				return
+			}
+			if err == nil && len(result.Suggestions) > 0 && SuggestionChannelTTL > 0 {
+				b.SetChannelDeleteTimer(channelid, SuggestionChannelTTL)
+				return
+			}
+			b.channelDelContext(ctx, channelid)
+		}()
+	}
+	if err != nil {
+		return nil, err
+	}
+	ctx = withLogFields(ctx, channelField(channelid), authField(userAuth))
+	ctxLogger(ctx).Debug("message sent, waiting for reply", zap.String("message_id", sentIds[len(sentIds)-1]), zap.Int("chunks", len(sentIds)))
+
+	// 建议按钮、结束标记、编辑静默(期间bot不在输入中)、收到回复后超过 TimeoutSec 没有活动, 任一条件满足即视为回复完成
+	detector := newCompletionDetector(req.completion, time.Now())
+	// bot 可能以多条消息回复, 每条消息的编辑各自更新后合并
+	aggregator := newReplyAggregator()
 	curcontent := ""
-	timer := time.NewTimer(60 * time.Second)
+	finish := func() (*SendResult, error) {
+		result := aggregator.result(curcontent)
+		result.BotId = req.botId
+		result.ChannelId = channelid
+		return result, nil
+	}
+	timer := time.NewTimer(time.Duration(req.completion.TimeoutSec) * time.Second)
 	defer timer.Stop()
+	resetTimer := func() {
+		wait, _, _ := detector.next(time.Now())
+		timer.Reset(max(wait, time.Millisecond))
+	}
 	for {
 		select {
-		case reply := <-replyChan:
-			timer.Reset(60 * time.Second)
-			curcontent = reply.Choices[0].Message.Content
-			if SliceContains(CozeErrorMessages, reply.Choices[0].Message.Content) {
-				if SliceContains(CozeDailyLimitErrorMessages, reply.Choices[0].Message.Content) {
-					logger.DefaultLogger.Warn(fmt.Sprintf("USER_AUTHORIZATION:%s DAILY LIMIT", b.authorization))
-					b.authorizations = FilterSlice(b.authorizations, b.authorization)
+		case update := <-replyChan:
+			reply := update.resp
+			aggregator.observe(update.message)
+			content, done := detector.reply(aggregator.update(reply.ID, reply.Choices[0].Message.Content), time.Now())
+			curcontent = content
+			if SliceContains(CozeErrorMessages, content) {
+				if SliceContains(CozeDailyLimitErrorMessages, content) {
+					ctxLogger(ctx).Warn("USER_AUTHORIZATION DAILY LIMIT")
+					b.auths.recordError(userAuth, errors.New("daily limit"), true)
 				}
+			} else {
+				req.stream.update(content)
+			}
+			if done {
+				ctxLogger(ctx).Debug("reply completed by end marker")
+				return finish()
 			}
+			resetTimer()
+		case <-c.typingC:
+			detector.typing(time.Now())
+			resetTimer()
 		case <-timer.C:
-			return "", errors.New("未获取到回复")
+			_, done, timeout := detector.next(time.Now())
+			switch {
+			case done:
+				ctxLogger(ctx).Debug("reply completed by quiescence or inactivity")
+				return finish()
+			case timeout:
+				ctxLogger(ctx).Warn("reply timed out")
+				return nil, errors.New("未获取到回复")
+			}
+			resetTimer()
 		case <-stopChan:
-			return curcontent, nil
+			return finish()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-b.life.abort:
+			return nil, ErrBotClosed
+		case <-lost:
+			return nil, ErrGatewayDisconnected
 		}
 	}
 }
 
 func (b *DiscordBot) SendRaw(message string) (*discordgo.Message, string, string, error) {
-	if b.session == nil {
-		logger.DefaultLogger.Error("discord session is nil")
-		return nil, "", "", fmt.Errorf("discord session not initialized")
-	}
-
-	//var sentMsg *discordgo.Message
-
-	content := fmt.Sprintf("%s \n <@%s>", message, b.botID)
+	return b.SendRawContext(context.Background(), message)
+}
 
-	content = strings.Replace(content, `\u0026`, "&", -1)
-	content = strings.Replace(content, `\u003c`, "<", -1)
-	content = strings.Replace(content, `\u003e`, ">", -1)
+// SendRawContext 同 SendRaw, 并将ctx中的trace与请求id贯穿发送流程
+func (b *DiscordBot) SendRawContext(ctx context.Context, message string) (*discordgo.Message, string, string, error) {
+	return lastMessage(b.sendRaw(ctx, b.defaultRequest(), message))
+}
 
-	tokens := CountTokens(content)
-	if tokens > 128*1000 {
-		logger.DefaultLogger.Error(fmt.Sprintf("prompt已超过限制,请分段发送 [%v] %s", tokens, content))
-		return nil, "", "", fmt.Errorf("prompt已超过限制,请分段发送 [%v]", tokens)
-	}
+func (b *DiscordBot) SendMessageSpec(channelid, bottoken, message string) (*discordgo.Message, string, string, error) {
+	return b.SendMessageSpecContext(context.Background(), channelid, bottoken, message)
+}
 
-	userAuth, err := RandomElement(b.authorizations)
-	if err != nil {
-		return nil, "", "", err
-	}
+// SendMessageSpecContext 同 SendMessageSpec, 并将ctx中的trace与请求id贯穿发送流程
+func (b *DiscordBot) SendMessageSpecContext(ctx context.Context, channelid, bottoken, message string) (*discordgo.Message, string, string, error) {
+	return lastMessage(b.sendRaw(ctx, sendRequest{botId: bottoken, channelId: channelid}, message))
+}
 
-	sendchannelid, err := b.GetSendChannelId()
+func lastMessage(sentIds []string, userAuth, channelId string, err error) (*discordgo.Message, string, string, error) {
 	if err != nil {
-		return nil, "", "", err
+		return nil, userAuth, channelId, err
 	}
-
-	for i, sendContent := range ReverseSegment(content, 1990) {
-		//sentMsg, myerr := Session.ChannelMessageSend(channelID, sendContent)
-		//sentMsgId := sentMsg.ID
-		// 4.0.0 版本下 用户端发送消息
-		sendContent = strings.ReplaceAll(sendContent, "\\n", "\n")
-		sentMsgId, err := b.SendMsgByAuthorization(userAuth, sendContent, sendchannelid)
-		if err != nil {
-			var myErr *DiscordUnauthorizedError
-			if errors.As(err, &myErr) {
-				// 无效则将此 auth 移除
-				b.authorizations = FilterSlice(b.authorizations, userAuth)
-				return b.SendRaw(message)
-			}
-			logger.DefaultLogger.Error(fmt.Sprintf("error sending message: %s", err))
-			return nil, sendchannelid, "", fmt.Errorf("error sending message")
-		}
-
-		time.Sleep(1 * time.Second)
-
-		if i == len(ReverseSegment(content, 1990))-1 {
-			return &discordgo.Message{
-				ID: sentMsgId,
-			}, userAuth, sendchannelid, nil
-		}
-	}
-	return &discordgo.Message{}, "", sendchannelid, fmt.Errorf("error sending message")
+	return &discordgo.Message{ID: sentIds[len(sentIds)-1]}, userAuth, channelId, nil
 }
 
-func (b *DiscordBot) SendMessageSpec(channelid, bottoken, message string) (*discordgo.Message, string, string, error) {
-	if b.session == nil {
-		logger.DefaultLogger.Error("discord session is nil")
+// sendRaw 发送消息, 返回按发送顺序排列的全部分段消息ID
+func (b *DiscordBot) sendRaw(ctx context.Context, req sendRequest, message string) ([]string, string, string, error) {
+	if b.getSession() == nil {
+		ctxLogger(ctx).Error("discord session is nil")
 		return nil, "", "", fmt.Errorf("discord session not initialized")
 	}
+	if b.gateway.unavailable() {
+		return nil, "", "", ErrGatewayDisconnected
+	}
 
-	//var sentMsg *discordgo.Message
-
-	content := fmt.Sprintf("%s \n <@%s>", message, b.botID)
-
-	content = strings.Replace(content, `\u0026`, "&", -1)
-	content = strings.Replace(content, `\u003c`, "<", -1)
-	content = strings.Replace(content, `\u003e`, ">", -1)
+	message = strings.Replace(message, `\u0026`, "&", -1)
+	message = strings.Replace(message, `\u003c`, "<", -1)
+	message = strings.Replace(message, `\u003e`, ">", -1)
+	message = strings.ReplaceAll(message, "\\n", "\n")
+	content := fmt.Sprintf("%s \n <@%s>", message, req.botId)
 
-	tokens := CountTokens(content)
+	tokens, err := CountTokens(content)
+	if err != nil {
+		return nil, "", "", err
+	}
 	if tokens > 128*1000 {
-		logger.DefaultLogger.Error(fmt.Sprintf("prompt已超过限制,请分段发送 [%v] %s", tokens, content))
+		ctxLogger(ctx).Error(fmt.Sprintf("prompt已超过限制,请分段发送 [%v]", tokens), zap.Int("tokens", tokens))
 		return nil, "", "", fmt.Errorf("prompt已超过限制,请分段发送 [%v]", tokens)
 	}
 
-	userAuth, err := RandomElement(b.authorizations)
+	userAuth, err := b.auths.acquire()
 	if err != nil {
 		return nil, "", "", err
 	}
+	defer func() { b.auths.release(userAuth) }()
 
-	for i, sendContent := range ReverseSegment(content, 1990) {
-		//sentMsg, myerr := Session.ChannelMessageSend(channelID, sendContent)
-		//sentMsgId := sentMsg.ID
-		// 4.0.0 版本下 用户端发送消息
-		sendContent = strings.ReplaceAll(sendContent, "\\n", "\n")
-		sentMsgId, err := b.SendMsgByAuthorization(userAuth, sendContent, channelid)
+	sendchannelid := req.channelId
+	if sendchannelid == "" {
+		sendchannelid, err = b.GetSendChannelIdContext(ctx)
 		if err != nil {
-			var myErr *DiscordUnauthorizedError
-			if errors.As(err, &myErr) {
-				// 无效则将此 auth 移除
-				b.authorizations = FilterSlice(b.authorizations, userAuth)
-				return b.SendRaw(message)
+			return nil, "", "", err
+		}
+	}
+	ctx = withLogFields(ctx, channelField(sendchannelid))
+
+	chunks := SplitMessage(content, MessageRuneLimit)
+	attachment := b.usePromptAttachment(tokens, len(chunks))
+	if attachment {
+		// 过长的prompt以附件发送, 只需一次请求
+		ctxLogger(ctx).Debug("sending prompt as attachment", zap.Int("tokens", tokens), zap.Int("chunks", len(chunks)))
+		chunks = []string{message}
+	}
+	var sentIds []string
+	for len(sentIds) < len(chunks) {
+		var sentMsgId string
+		if attachment {
+			sentMsgId, err = b.sendPromptAttachment(ctx, userAuth, req.botId, sendchannelid, message)
+		} else {
+			// 4.0.0 版本下 用户端发送消息
+			sentMsgId, err = b.SendMsgByAuthorizationContext(ctx, userAuth, chunks[len(sentIds)], sendchannelid)
+		}
+		if err == nil {
+			sentIds = append(sentIds, sentMsgId)
+			req.sent(sendchannelid, sentMsgId)
+			if !attachment {
+				// 分段之间保持间隔, 以附件发送时只有一次请求
+				time.Sleep(1 * time.Second)
 			}
-			logger.DefaultLogger.Error(fmt.Sprintf("error sending message: %s", err))
-			return nil, "", "", fmt.Errorf("error sending message")
+			continue
 		}
 
-		time.Sleep(1 * time.Second)
-
-		if i == len(ReverseSegment(content, 1990))-1 {
-			return &discordgo.Message{
-				ID: sentMsgId,
-			}, userAuth, channelid, nil
+		var myErr *DiscordUnauthorizedError
+		if !errors.As(err, &myErr) {
+			b.auths.recordError(userAuth, err, false)
+			ctxLogger(ctx).Error("error sending message", authField(userAuth), zap.Error(err))
+			return nil, "", sendchannelid, fmt.Errorf("error sending message: %w", err)
+		}
+		// 无效则暂停使用此 auth, 换一个 auth 从失败的分段继续发送, 已发送的分段不重复发送
+		b.auths.recordError(userAuth, err, true)
+		b.auths.release(userAuth)
+		if userAuth, err = b.auths.acquire(); err != nil {
+			userAuth = ""
+			return nil, "", sendchannelid, err
 		}
+		ctxLogger(ctx).Warn("user authorization invalid, resuming with another", zap.Int("sent", len(sentIds)))
 	}
-	return &discordgo.Message{}, "", "", fmt.Errorf("error sending message")
+	return sentIds, userAuth, sendchannelid, nil
+}
+
+func (b *DiscordBot) getUserAgent() string {
+	if b.userAgent != "" {
+		return b.userAgent
+	}
+	return "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36"
 }
 
 // 用户端发送消息 注意 此为临时解决方案 后续会优化代码
 func (b *DiscordBot) SendMsgByAuthorization(userAuth, content, channelId string) (string, error) {
-	postUrl := "https://discord.com/api/v9/channels/%s/messages"
+	return b.SendMsgByAuthorizationContext(context.Background(), userAuth, content, channelId)
+}
 
-	// 构造请求体
-	requestBody, err := json.Marshal(map[string]interface{}{
-		"content": content,
-	})
-	if err != nil {
-		logger.DefaultLogger.Error(fmt.Sprintf("Error encoding request body:%s", err))
-		return "", err
-	}
+// SendMsgByAuthorizationContext 同 SendMsgByAuthorization, 并将ctx中的trace与请求id贯穿发送流程
+func (b *DiscordBot) SendMsgByAuthorizationContext(ctx context.Context, userAuth, content, channelId string) (msgId string, err error) {
+	ctx, span := startSpan(ctx, "discord.SendMsgByAuthorization", AttrChannelId.String(channelId))
+	defer func() {
+		span.SetAttributes(AttrMessageId.String(msgId))
+		endSpan(span, err)
+	}()
+	return b.postUserMessage(ctx, userAuth, channelId, map[string]interface{}{"content": content}, nil)
+}
+
+// SendFileByAuthorization 以用户身份发送带附件的消息
+func (b *DiscordBot) SendFileByAuthorization(ctx context.Context, userAuth, content, channelId string, files []*discordgo.File) (msgId string, err error) {
+	ctx, span := startSpan(ctx, "discord.SendFileByAuthorization", AttrChannelId.String(channelId))
+	defer func() {
+		span.SetAttributes(AttrMessageId.String(msgId))
+		endSpan(span, err)
+	}()
+	return b.postUserMessage(ctx, userAuth, channelId, map[string]interface{}{"content": content}, files)
+}
+
+// postUserMessage 以用户token在频道中发送消息, files 不为空时以 multipart 上传附件
+func (b *DiscordBot) postUserMessage(ctx context.Context, userAuth, channelId string, payload map[string]interface{}, files []*discordgo.File) (string, error) {
+	log := ctxLogger(ctx).With(channelField(channelId), authField(userAuth))
 
-	req, err := http.NewRequest("POST", fmt.Sprintf(postUrl, channelId), bytes.NewBuffer(requestBody))
+	postUrl := "https://discord.com/api/v9/channels/%s/messages"
+
+	// 构造请求体, nonce 使限流重试时discord不会重复创建消息
+	nonce, err := NextID()
 	if err != nil {
-		logger.DefaultLogger.Error(fmt.Sprintf("Error creating request:%s", err))
 		return "", err
 	}
+	payload["nonce"] = nonce
+	payload["enforce_nonce"] = true
 
-	// 设置请求头-部分请求头不传没问题，但目前仍有被discord检测异常的风险
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", userAuth)
-	req.Header.Set("Origin", "https://discord.com")
-	req.Header.Set("Referer", fmt.Sprintf("https://discord.com/channels/%s/%s", b.guildID, channelId))
-	if b.userAgent != "" {
-		req.Header.Set("User-Agent", b.userAgent)
+	contentType := "application/json"
+	var requestBody []byte
+	if len(files) > 0 {
+		contentType, requestBody, err = discordgo.MultipartBodyWithJSON(payload, files)
 	} else {
-		req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36")
-	}
-
-	// 发起请求
-	client := &http.Client{}
-	if b.proxyurl != "" {
-		proxyURL, _ := url.Parse(b.proxyurl)
-		transport := &http.Transport{
-			Proxy: http.ProxyURL(proxyURL),
-		}
-		client = &http.Client{
-			Transport: transport,
-		}
+		requestBody, err = json.Marshal(payload)
 	}
-
-	resp, err := client.Do(req)
 	if err != nil {
-		logger.DefaultLogger.Error(fmt.Sprintf("Error sending request:%s", err))
+		log.Error("Error encoding request body", zap.Error(err))
 		return "", err
 	}
-	defer resp.Body.Close()
 
-	// 读取响应体
-	bodyBytes, err := io.ReadAll(resp.Body)
+	// 临时频道只使用一次, 限流按路由bucket共享, 不按频道区分
+	major := channelId
+	if b.isTempChannel(channelId) {
+		major = ""
+	}
+	// 发起请求, 被限流时按 Retry-After 等待后重试
+	resp, bodyBytes, err := b.doUserRequest(ctx, userAuth, "POST /channels/{channel.id}/messages", major, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf(postUrl, channelId), bytes.NewReader(requestBody))
+		if err != nil {
+			return nil, err
+		}
+		// 设置请求头-部分请求头不传没问题，但目前仍有被discord检测异常的风险
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Origin", "https://discord.com")
+		req.Header.Set("Referer", fmt.Sprintf("https://discord.com/channels/%s/%s", b.guildID, channelId))
+		return req, nil
+	})
 	if err != nil {
+		log.Error("Error sending request", zap.Error(err))
 		return "", err
 	}
 
@@ -460,7 +659,7 @@ func (b *DiscordBot) SendMsgByAuthorization(userAuth, content, channelId string)
 	var result map[string]interface{}
 
 	// 解码JSON到map中
-	err = json.Unmarshal([]byte(bodyString), &result)
+	err = json.Unmarshal(bodyBytes, &result)
 	if err != nil {
 		return "", err
 	}
@@ -473,15 +672,15 @@ func (b *DiscordBot) SendMsgByAuthorization(userAuth, content, channelId string)
 		if errMessage, ok := result["message"].(string); ok {
 			if strings.Contains(errMessage, "401: Unauthorized") ||
 				strings.Contains(errMessage, "You need to verify your account in order to perform this action.") {
-				logger.DefaultLogger.Warn(fmt.Sprintf("USER_AUTHORIZATION:%s EXPIRED", userAuth))
+				log.Warn("USER_AUTHORIZATION EXPIRED")
 				return "", &DiscordUnauthorizedError{
 					ErrCode: 401,
 					Message: "discord 鉴权未通过",
 				}
 			}
 		}
-		logger.DefaultLogger.Error(fmt.Sprintf("user_auth:%s result:%s", userAuth, bodyString))
-		return "", fmt.Errorf("/api/v9/channels/%s/messages response myerr", channelId)
+		log.Error("unexpected send response", zap.Int("status", resp.StatusCode), zap.String("result", bodyString))
+		return "", fmt.Errorf("/api/v9/channels/%s/messages status %d: %s", channelId, resp.StatusCode, bodyString)
 	} else {
 		return id, nil
 	}
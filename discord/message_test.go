@@ -7,8 +7,7 @@ import (
 
 func TestBotMessage(t *testing.T) {
 	if testDiscordBot == nil {
-		t.Error("未正确初始化")
-		return
+		t.Skip("未配置 testdata/.env, 跳过联调测试")
 	}
 
 	msgs, err := testDiscordBot.SendPlain("你好，你叫什么名字，你可以做些什么")
@@ -21,8 +20,7 @@ func TestBotMessage(t *testing.T) {
 
 func TestBotTTS(t *testing.T) {
 	if testDiscordBot == nil {
-		t.Error("未正确初始化")
-		return
+		t.Skip("未配置 testdata/.env, 跳过联调测试")
 	}
 
 	msgs, err := testDiscordBot.SendPlain("你好，请用女声阅读括号中的话: (帅哥你好，我叫小美)")
@@ -0,0 +1,243 @@
+package discord
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Priority 请求优先级, interactive 请求总是先于 batch 请求被放行
+type Priority int
+
+const (
+	PriorityInteractive Priority = iota
+	PriorityBatch
+)
+
+// PriorityHeader 请求头, 值为 batch 时以批量优先级排队, 其余按 interactive 处理
+const PriorityHeader = "X-Priority"
+
+var ErrQueueTimeout = errors.New("timed out waiting in request queue")
+
+func (p Priority) String() string {
+	if p == PriorityBatch {
+		return "batch"
+	}
+	return "interactive"
+}
+
+// ParsePriority 解析优先级, 无法识别时返回 PriorityInteractive
+func ParsePriority(s string) Priority {
+	if strings.EqualFold(strings.TrimSpace(s), "batch") {
+		return PriorityBatch
+	}
+	return PriorityInteractive
+}
+
+// ContextWithPriority 设置请求在发送队列中的优先级
+func ContextWithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, ctxKey(PriorityHeader), p)
+}
+
+func priorityFromContext(ctx context.Context) Priority {
+	p, _ := ctx.Value(ctxKey(PriorityHeader)).(Priority)
+	return p
+}
+
+// QueueConfig 发送队列配置, 为0的项不限制
+type QueueConfig struct {
+	MaxInflight int // 服务器内同时处理的请求数上限
+	// InflightPerUsableAuth 按可用用户授权数扩展的总上限: 同时处理的请求数不超过 可用授权数 × 该值.
+	// 不限制单个授权, 请求发送时由授权池选择正在发送数最少的授权
+	InflightPerUsableAuth int
+	InteractiveTimeout    time.Duration // interactive 请求最长排队时间
+	BatchTimeout          time.Duration // batch 请求最长排队时间
+}
+
+// QueueStats 发送队列状态及排队耗时统计
+type QueueStats struct {
+	Limit              int     `json:"limit"`
+	Inflight           int     `json:"inflight"`
+	InteractiveWaiting int     `json:"interactiveWaiting"`
+	BatchWaiting       int     `json:"batchWaiting"`
+	Admitted           int64   `json:"admitted"`
+	TimedOut           int64   `json:"timedOut"`
+	AvgWaitMs          float64 `json:"avgWaitMs"`
+	MaxWaitMs          int64   `json:"maxWaitMs"`
+}
+
+type queueWaiter struct {
+	ready    chan struct{}
+	admitted bool
+}
+
+// admissionQueue 发送前的准入队列: 限制同时处理的请求数, 按优先级分道、道内先进先出
+type admissionQueue struct {
+	mu        sync.Mutex
+	config    QueueConfig
+	usable    func() int
+	inflight  int
+	lanes     [2]*list.List
+	admitted  int64
+	timedOut  int64
+	totalWait time.Duration
+	maxWait   time.Duration
+	waitHist  metric.Float64Histogram
+}
+
+// WithQueue 开启发送队列
+func WithQueue(config QueueConfig) WithConfig {
+	return func(db *DiscordBot) {
+		db.queue = newAdmissionQueue(config, func() int { return len(db.auths.usable()) })
+	}
+}
+
+func newAdmissionQueue(config QueueConfig, usable func() int) *admissionQueue {
+	q := &admissionQueue{
+		config: config,
+		usable: usable,
+		lanes:  [2]*list.List{list.New(), list.New()},
+	}
+	// 未注册 MeterProvider 时为空实现
+	q.waitHist, _ = otel.Meter(tracerName).Float64Histogram("gobot.queue.wait",
+		metric.WithDescription("time requests spent waiting in the send queue"),
+		metric.WithUnit("s"))
+	return q
+}
+
+// limitLocked 当前允许同时处理的请求数, 0 表示不限制
+func (q *admissionQueue) limitLocked() int {
+	limit := q.config.MaxInflight
+	if q.config.InflightPerUsableAuth > 0 {
+		// 没有可用授权时不按授权限制, 由发送环节直接报错
+		if n := q.usable(); n > 0 && (limit == 0 || n*q.config.InflightPerUsableAuth < limit) {
+			limit = n * q.config.InflightPerUsableAuth
+		}
+	}
+	return limit
+}
+
+func (q *admissionQueue) timeout(p Priority) time.Duration {
+	if p == PriorityBatch {
+		return q.config.BatchTimeout
+	}
+	return q.config.InteractiveTimeout
+}
+
+// acquire 排队直到获得处理名额, 返回的函数用于归还名额. 超过排队时间返回 ErrQueueTimeout
+func (q *admissionQueue) acquire(ctx context.Context) (func(), error) {
+	p := priorityFromContext(ctx)
+	start := time.Now()
+
+	q.mu.Lock()
+	limit := q.limitLocked()
+	if q.lanes[PriorityInteractive].Len() == 0 && (p == PriorityInteractive || q.lanes[PriorityBatch].Len() == 0) &&
+		(limit == 0 || q.inflight < limit) {
+		q.inflight++
+		q.observeLocked(ctx, p, 0)
+		q.mu.Unlock()
+		return q.release, nil
+	}
+	w := &queueWaiter{ready: make(chan struct{})}
+	el := q.lanes[p].PushBack(w)
+	q.mu.Unlock()
+
+	var expired <-chan time.Time
+	if d := q.timeout(p); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var err error
+	select {
+	case <-w.ready:
+		q.mu.Lock()
+		q.observeLocked(ctx, p, time.Since(start))
+		q.mu.Unlock()
+		return q.release, nil
+	case <-expired:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if w.admitted {
+		// 放弃的同时已被放行, 归还名额
+		q.inflight--
+		q.dispatchLocked()
+	} else {
+		q.lanes[p].Remove(el)
+	}
+	if errors.Is(err, ErrQueueTimeout) {
+		q.timedOut++
+	}
+	return nil, err
+}
+
+func (q *admissionQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.inflight--
+	q.dispatchLocked()
+}
+
+// dispatchLocked 按优先级依次放行等待中的请求
+func (q *admissionQueue) dispatchLocked() {
+	limit := q.limitLocked()
+	for limit == 0 || q.inflight < limit {
+		lane := q.lanes[PriorityInteractive]
+		if lane.Len() == 0 {
+			lane = q.lanes[PriorityBatch]
+		}
+		if lane.Len() == 0 {
+			return
+		}
+		w := lane.Remove(lane.Front()).(*queueWaiter)
+		w.admitted = true
+		close(w.ready)
+		q.inflight++
+	}
+}
+
+func (q *admissionQueue) observeLocked(ctx context.Context, p Priority, wait time.Duration) {
+	q.admitted++
+	q.totalWait += wait
+	q.maxWait = max(q.maxWait, wait)
+	q.waitHist.Record(ctx, wait.Seconds(), metric.WithAttributes(attribute.String("priority", p.String())))
+}
+
+func (q *admissionQueue) stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := QueueStats{
+		Limit:              q.limitLocked(),
+		Inflight:           q.inflight,
+		InteractiveWaiting: q.lanes[PriorityInteractive].Len(),
+		BatchWaiting:       q.lanes[PriorityBatch].Len(),
+		Admitted:           q.admitted,
+		TimedOut:           q.timedOut,
+		MaxWaitMs:          q.maxWait.Milliseconds(),
+	}
+	if q.admitted > 0 {
+		stats.AvgWaitMs = float64(q.totalWait.Milliseconds()) / float64(q.admitted)
+	}
+	return stats
+}
+
+// QueueStats 返回发送队列状态, 未开启队列时返回零值
+func (b *DiscordBot) QueueStats() QueueStats {
+	if b.queue == nil {
+		return QueueStats{}
+	}
+	return b.queue.stats()
+}
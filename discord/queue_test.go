@@ -0,0 +1,67 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdmissionQueuePriority(t *testing.T) {
+	q := newAdmissionQueue(QueueConfig{MaxInflight: 1}, func() int { return 1 })
+	release, err := q.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan Priority, 2)
+	wait := func(p Priority) {
+		release, err := q.acquire(ContextWithPriority(context.Background(), p))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		order <- p
+		release()
+	}
+	go wait(PriorityBatch)
+	waitFor(t, func() bool { return q.stats().BatchWaiting == 1 })
+	go wait(PriorityInteractive)
+	waitFor(t, func() bool { return q.stats().InteractiveWaiting == 1 })
+
+	release()
+	if first, second := <-order, <-order; first != PriorityInteractive || second != PriorityBatch {
+		t.Fatalf("expected interactive before batch, got %s then %s", first, second)
+	}
+	if stats := q.stats(); stats.Inflight != 0 || stats.Admitted != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestAdmissionQueueTimeout(t *testing.T) {
+	q := newAdmissionQueue(QueueConfig{InflightPerUsableAuth: 1, BatchTimeout: 10 * time.Millisecond}, func() int { return 1 })
+	release, err := q.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	_, err = q.acquire(ContextWithPriority(context.Background(), PriorityBatch))
+	if !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("expected ErrQueueTimeout, got %v", err)
+	}
+	if stats := q.stats(); stats.TimedOut != 1 || stats.BatchWaiting != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,226 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// UserRateLimitRetries 用户token请求被限流(429)后的最大重试次数
+var UserRateLimitRetries = 3
+
+// MaxRateLimitWait ctx 没有截止时间时被限流的最长等待时间, 超过时直接返回 *RateLimitedError
+var MaxRateLimitWait = 30 * time.Second
+
+// rateLimitSweepInterval 清理已过期限流状态的间隔
+const rateLimitSweepInterval = time.Minute
+
+// RateLimitedError 用户token请求被discord限流, 且等待时间超过调用方的截止时间或重试次数已用完
+type RateLimitedError struct {
+	Bucket     string
+	Global     bool
+	RetryAfter time.Duration
+	ErrCode    int
+}
+
+func (e *RateLimitedError) Error() string {
+	scope := "bucket " + e.Bucket
+	if e.Global {
+		scope = "global"
+	}
+	return fmt.Sprintf("discord rate limited (%s), retry after %s", scope, e.RetryAfter)
+}
+
+type rateBucket struct {
+	remaining int
+	resetAt   time.Time
+}
+
+// rateLimiter 按用户授权记录discord REST限流状态: 路由到bucket的映射、各bucket剩余次数以及全局限流.
+// 已过重置时间的状态不再有意义, 查询时及定期清理, 避免按临时频道区分的bucket无限增长
+type rateLimiter struct {
+	mu        sync.Mutex
+	routes    map[string]string
+	buckets   map[string]*rateBucket
+	global    map[string]time.Time
+	lastSweep time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		routes:  map[string]string{},
+		buckets: map[string]*rateBucket{},
+		global:  map[string]time.Time{},
+	}
+}
+
+// bucketKeyLocked 路由对应的bucket, 未知时以路由本身作为bucket; 同一bucket按 major 参数(如频道id)分别限流
+func (l *rateLimiter) bucketKeyLocked(authId, route, major string) string {
+	bucket, ok := l.routes[route]
+	if !ok {
+		bucket = route
+	}
+	return authId + " " + bucket + " " + major
+}
+
+// resetAt 返回需要等待到的时间, 以及是否为全局限流
+func (l *rateLimiter) resetAt(authId, route, major string) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if until, ok := l.global[authId]; ok {
+		if now.Before(until) {
+			return until, true
+		}
+		delete(l.global, authId)
+	}
+	key := l.bucketKeyLocked(authId, route, major)
+	if b, ok := l.buckets[key]; ok && !now.Before(b.resetAt) {
+		delete(l.buckets, key)
+	} else if ok && b.remaining <= 0 {
+		return b.resetAt, false
+	}
+	return time.Time{}, false
+}
+
+// sweepLocked 清理已过重置时间的bucket与全局限流, 每 rateLimitSweepInterval 最多执行一次
+func (l *rateLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if !now.Before(b.resetAt) {
+			delete(l.buckets, key)
+		}
+	}
+	for authId, until := range l.global {
+		if !now.Before(until) {
+			delete(l.global, authId)
+		}
+	}
+}
+
+// update 根据响应头更新限流状态, 429 时返回需要等待的时长
+func (l *rateLimiter) update(authId, route, major string, resp *http.Response, body []byte) (retryAfter time.Duration, global bool) {
+	header := resp.Header
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweepLocked(time.Now())
+
+	if bucket := header.Get("X-RateLimit-Bucket"); bucket != "" {
+		l.routes[route] = bucket
+	}
+	key := l.bucketKeyLocked(authId, route, major)
+	if remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining")); err == nil {
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &rateBucket{}
+			l.buckets[key] = b
+		}
+		b.remaining = remaining
+		if resetAfter, err := strconv.ParseFloat(header.Get("X-RateLimit-Reset-After"), 64); err == nil {
+			b.resetAt = time.Now().Add(time.Duration(resetAfter * float64(time.Second)))
+		}
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	var payload struct {
+		RetryAfter float64 `json:"retry_after"`
+		Global     bool    `json:"global"`
+	}
+	json.Unmarshal(body, &payload)
+	if seconds, err := strconv.ParseFloat(header.Get("Retry-After"), 64); err == nil && seconds > payload.RetryAfter {
+		payload.RetryAfter = seconds
+	}
+	retryAfter = time.Duration(payload.RetryAfter * float64(time.Second))
+	global = payload.Global || header.Get("X-RateLimit-Global") == "true" || header.Get("X-RateLimit-Scope") == "global"
+	until := time.Now().Add(retryAfter)
+	if global {
+		l.global[authId] = until
+	} else {
+		l.buckets[key] = &rateBucket{remaining: 0, resetAt: until}
+	}
+	return retryAfter, global
+}
+
+// waitUntil 等待到 until, 超过ctx截止时间(没有截止时间时为 MaxRateLimitWait)时直接返回 *RateLimitedError
+func waitUntil(ctx context.Context, until time.Time, err *RateLimitedError) error {
+	d := time.Until(until)
+	if d <= 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(until) {
+		return err
+	} else if !ok && d > MaxRateLimitWait {
+		return err
+	}
+	ctxLogger(ctx).Warn("rate limited, waiting", zap.Duration("retry_after", d), zap.Bool("global", err.Global))
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// doUserRequest 以用户token发起discord REST请求: 经由该token配置的代理与客户端标识发送,
+// 按限流状态提前等待, 429 时等待 Retry-After 后重试.
+// route 为路由模板, 如 "POST /channels/{channel.id}/messages", major 为其中的频道id; newReq 每次重试都会被调用以重建请求
+func (b *DiscordBot) doUserRequest(ctx context.Context, userAuth, route, major string, newReq func() (*http.Request, error)) (*http.Response, []byte, error) {
+	profile, client, err := b.userClient(userAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+	authId := AuthId(userAuth)
+	for attempt := 0; ; attempt++ {
+		if until, global := b.rateLimits.resetAt(authId, route, major); !until.IsZero() {
+			err := &RateLimitedError{Bucket: route, Global: global, RetryAfter: time.Until(until), ErrCode: http.StatusTooManyRequests}
+			if err := waitUntil(ctx, until, err); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		req, err := newReq()
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Header.Set("Authorization", userAuth)
+		req.Header.Set("User-Agent", b.getUserAgent())
+		if profile.UserAgent != "" {
+			req.Header.Set("User-Agent", profile.UserAgent)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		retryAfter, global := b.rateLimits.update(authId, route, major, resp, body)
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return resp, body, nil
+		}
+		rateErr := &RateLimitedError{Bucket: route, Global: global, RetryAfter: retryAfter, ErrCode: http.StatusTooManyRequests}
+		if attempt >= UserRateLimitRetries {
+			return nil, nil, rateErr
+		}
+		if err := waitUntil(ctx, time.Now().Add(retryAfter), rateErr); err != nil {
+			return nil, nil, err
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newRateLimitServer(retryAfter string, limited int) (*httptest.Server, *int) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= limited {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"message": "You are being rate limited.", "global": false}`))
+			return
+		}
+		w.Write([]byte(`{"id": "1"}`))
+	}))
+	return srv, &calls
+}
+
+func TestDoUserRequestRetriesAfterRateLimit(t *testing.T) {
+	srv, calls := newRateLimitServer("0.01", 1)
+	defer srv.Close()
+
+	b := NewDiscordBot("")
+	resp, body, err := b.doUserRequest(context.Background(), "token", "GET /test", "", func() (*http.Request, error) {
+		return http.NewRequest("GET", srv.URL, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != `{"id": "1"}` || *calls != 2 {
+		t.Fatalf("unexpected result: status %d body %s calls %d", resp.StatusCode, body, *calls)
+	}
+}
+
+func TestDoUserRequestDeadline(t *testing.T) {
+	srv, calls := newRateLimitServer("5", 1)
+	defer srv.Close()
+
+	b := NewDiscordBot("")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	newReq := func() (*http.Request, error) { return http.NewRequest("GET", srv.URL, nil) }
+
+	_, _, err := b.doUserRequest(ctx, "token", "GET /test", "", newReq)
+	var rateErr *RateLimitedError
+	if !errors.As(err, &rateErr) || rateErr.RetryAfter != 5*time.Second {
+		t.Fatalf("expected RateLimitedError, got %v", err)
+	}
+	// 已知bucket仍在限流中, 不再发起请求
+	if _, _, err := b.doUserRequest(ctx, "token", "GET /test", "", newReq); !errors.As(err, &rateErr) || *calls != 1 {
+		t.Fatalf("expected RateLimitedError without a request, got %v after %d calls", err, *calls)
+	}
+}
+
+func TestRateLimiterPrunesExpiredBuckets(t *testing.T) {
+	l := newRateLimiter()
+	for _, channel := range []string{"1", "2", "3"} {
+		resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+		resp.Header.Set("X-RateLimit-Remaining", "0")
+		resp.Header.Set("X-RateLimit-Reset-After", "0.01")
+		l.update("auth", "POST /channels/{channel.id}/messages", channel, resp, nil)
+	}
+	if until, _ := l.resetAt("auth", "POST /channels/{channel.id}/messages", "1"); until.IsZero() {
+		t.Fatal("bucket should still be limited before reset")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if until, _ := l.resetAt("auth", "POST /channels/{channel.id}/messages", "1"); !until.IsZero() {
+		t.Fatal("bucket should be released after reset")
+	}
+	l.mu.Lock()
+	l.lastSweep = time.Time{}
+	l.sweepLocked(time.Now())
+	n := len(l.buckets)
+	l.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("expired buckets should be pruned, %d left", n)
+	}
+}
+
+func TestDoUserRequestCapsWaitWithoutDeadline(t *testing.T) {
+	srv, calls := newRateLimitServer("60", 1)
+	defer srv.Close()
+
+	b := NewDiscordBot("")
+	start := time.Now()
+	_, _, err := b.doUserRequest(context.Background(), "token", "GET /test", "", func() (*http.Request, error) {
+		return http.NewRequest("GET", srv.URL, nil)
+	})
+	var rateErr *RateLimitedError
+	if !errors.As(err, &rateErr) || *calls != 1 || time.Since(start) > 5*time.Second {
+		t.Fatalf("expected RateLimitedError without waiting, got %v after %d calls", err, *calls)
+	}
+}
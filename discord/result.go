@@ -0,0 +1,105 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/wwqdrh/gobot/types"
+)
+
+var ErrSuggestionNotFound = errors.New("suggestion not found in the reply")
+
+// SuggestionChannelTTL 回复带有建议时临时频道的保留时长, 期间可通过 ClickSuggestion 继续对话, 为0时回复后立即删除
+var SuggestionChannelTTL = 10 * time.Minute
+
+// SendResult 一次发送的完整回复. bot 以多条消息回复时按消息顺序合并
+type SendResult struct {
+	Content     string                         `json:"content"`
+	Suggestions []string                       `json:"suggestions"`
+	Embeds      []*discordgo.MessageEmbed      `json:"embeds"`
+	Attachments []*discordgo.MessageAttachment `json:"attachments"`
+	BotId       string                         `json:"botId"`
+	// ChannelId 回复所在频道. 临时频道仅在回复带有建议时保留 SuggestionChannelTTL, 之后无法继续点击建议
+	ChannelId string `json:"channelId"`
+	// Cached 回复来自响应缓存
+	Cached bool `json:"cached"`
+}
+
+// imageCount 回复中的图片数: 图片附件与带图片的嵌入, 缓存命中的回复不含附件, 不计入
+func (r *SendResult) imageCount() int {
+	n := 0
+	for _, attachment := range r.Attachments {
+		if attachment != nil && strings.HasPrefix(attachment.ContentType, "image/") {
+			n++
+		}
+	}
+	for _, embed := range r.Embeds {
+		if embed != nil && embed.Image != nil && embed.Image.URL != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// replyUpdate 回复消息的创建或编辑, message 用于收集嵌入、附件与建议按钮
+type replyUpdate struct {
+	resp    types.OpenAIChatCompletionResponse
+	message *discordgo.Message
+}
+
+// parseSuggestions 收集所有 ActionsRow 中按钮的文字, 跳过链接按钮及其它类型的组件
+func parseSuggestions(components []discordgo.MessageComponent) []string {
+	var suggestions []string
+	for _, component := range components {
+		row, ok := component.(*discordgo.ActionsRow)
+		if !ok || row == nil {
+			continue
+		}
+		for _, child := range row.Components {
+			button, ok := child.(*discordgo.Button)
+			if !ok || button == nil || button.Style == discordgo.LinkButton || button.Label == "" {
+				continue
+			}
+			suggestions = append(suggestions, button.Label)
+		}
+	}
+	return suggestions
+}
+
+// Send 发送消息并等待回复, 返回内容、建议、嵌入与附件
+func (b *DiscordBot) Send(ctx context.Context, message string) (*SendResult, error) {
+	return b.sendPlain(ctx, b.defaultRequest(), message)
+}
+
+// SendTo 在指定频道@指定bot发送消息并等待回复, 回复中的建议可通过 ClickSuggestion 继续对话
+func (b *DiscordBot) SendTo(ctx context.Context, channelId, botId, message string) (*SendResult, error) {
+	req := b.defaultRequest()
+	req.botId = botId
+	req.channelId = channelId
+	req.completion = b.completionConfig(b.botConfigCompletion(botId, channelId))
+	return b.sendPlain(ctx, req, message)
+}
+
+// ClickSuggestion 以 suggestion 的内容在原频道继续发送消息, 相当于点击回复中的建议按钮
+func (b *DiscordBot) ClickSuggestion(ctx context.Context, result *SendResult, suggestion string) (*SendResult, error) {
+	if !SliceContains(result.Suggestions, suggestion) {
+		return nil, ErrSuggestionNotFound
+	}
+	if result.ChannelId == "" {
+		return nil, errors.New("the reply has no channel to continue in")
+	}
+	return b.SendTo(ctx, result.ChannelId, result.BotId, suggestion)
+}
+
+// botConfigCompletion botId 与 channelId 对应的 BotConfig 中的完成判定条件
+func (b *DiscordBot) botConfigCompletion(botId, channelId string) *CompletionConfig {
+	for _, config := range b.BotConfigs() {
+		if config.BotId == botId && config.ChannelId == channelId {
+			return config.Completion
+		}
+	}
+	return nil
+}
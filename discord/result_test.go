@@ -0,0 +1,71 @@
+package discord
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestParseSuggestions(t *testing.T) {
+	components := []discordgo.MessageComponent{
+		&discordgo.Button{Label: "not in a row"},
+		&discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			&discordgo.Button{Label: "first"},
+			&discordgo.SelectMenu{CustomID: "menu"},
+			&discordgo.Button{Label: "docs", Style: discordgo.LinkButton, URL: "https://example.com"},
+		}},
+		&discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			&discordgo.Button{Label: "second"},
+		}},
+	}
+	suggestions := parseSuggestions(components)
+	if len(suggestions) != 2 || suggestions[0] != "first" || suggestions[1] != "second" {
+		t.Fatalf("unexpected suggestions: %q", suggestions)
+	}
+}
+
+func TestReplyAggregatorResult(t *testing.T) {
+	a := newReplyAggregator()
+	image := &discordgo.MessageEmbed{Image: &discordgo.MessageEmbedImage{URL: "https://example.com/a.png"}}
+	a.observe(&discordgo.Message{ID: "2", Components: []discordgo.MessageComponent{
+		&discordgo.ActionsRow{Components: []discordgo.MessageComponent{&discordgo.Button{Label: "more"}}},
+	}})
+	a.update("2", "second")
+	a.observe(&discordgo.Message{ID: "1", Embeds: []*discordgo.MessageEmbed{image},
+		Attachments: []*discordgo.MessageAttachment{{Filename: "a.txt"}}})
+	a.update("1", "first")
+	// 编辑事件未携带嵌入时保留之前的值
+	a.observe(&discordgo.Message{ID: "1"})
+
+	result := a.result(a.content())
+	if result.Content != "first\nsecond" || len(result.Embeds) != 1 || len(result.Attachments) != 1 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if len(result.Suggestions) != 1 || result.Suggestions[0] != "more" {
+		t.Fatalf("unexpected suggestions: %q", result.Suggestions)
+	}
+
+	b := NewDiscordBot("")
+	if _, err := b.ClickSuggestion(context.Background(), result, "unknown"); err != ErrSuggestionNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSendResultImageCount(t *testing.T) {
+	result := &SendResult{
+		// 内容中的图片链接不计入, 以附件与嵌入为准
+		Content: "![Image](https://example.com/a.png)",
+		Embeds: []*discordgo.MessageEmbed{
+			{Image: &discordgo.MessageEmbedImage{URL: "https://example.com/b.png"}},
+			{Description: "text only"},
+		},
+		Attachments: []*discordgo.MessageAttachment{
+			{Filename: "c.png", ContentType: "image/png"},
+			{Filename: "d.txt", ContentType: "text/plain"},
+		},
+	}
+	if got := result.imageCount(); got != 2 {
+		t.Fatalf("imageCount = %d, want 2", got)
+	}
+}
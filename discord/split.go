@@ -0,0 +1,163 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// MessageRuneLimit 单条discord消息的字数上限(预留部分余量)
+const MessageRuneLimit = 1990
+
+const codeFence = "```"
+
+// PromptAttachmentInstruction 以附件发送prompt时消息正文的说明
+var PromptAttachmentInstruction = "完整的问题内容见附件 prompt.txt, 请阅读附件后直接回答。"
+
+// PromptAttachmentConfig prompt 以 prompt.txt 附件发送的阈值, 超过任一阈值即以附件发送, 为0的项不启用.
+// 一次上传代替数十条分段消息及其间隔, 显著减少发送耗时与API调用
+type PromptAttachmentConfig struct {
+	MaxChunks int // prompt 拆分后的段数上限
+	MaxTokens int // prompt 的token数上限
+}
+
+// WithPromptAttachment 过长的prompt改为以附件发送
+func WithPromptAttachment(config PromptAttachmentConfig) WithConfig {
+	return func(db *DiscordBot) {
+		db.promptAttachmentConfig = config
+	}
+}
+
+// usePromptAttachment 是否以附件方式发送prompt
+func (b *DiscordBot) usePromptAttachment(tokens, chunks int) bool {
+	config := b.promptAttachmentConfig
+	return (config.MaxTokens > 0 && tokens > config.MaxTokens) ||
+		(config.MaxChunks > 0 && chunks > config.MaxChunks)
+}
+
+// protectedPattern 不可被拆开的内容: URL、用户/角色/频道提及以及自定义表情
+var protectedPattern = regexp.MustCompile(`https?://\S+|<(?:@[!&]?|#)\d+>|<a?:\w+:\d+>`)
+
+// sentenceEnds 句子结尾, 拆分点位于其后
+var sentenceEnds = []string{". ", "! ", "? ", "。", "！", "？", "; ", "；"}
+
+// SplitMessage 将内容拆分为不超过 limit 个字符的多段: 依次优先在段落、换行、句子、空白处拆分,
+// 不拆开URL与提及; 代码块跨段时在当前段末尾闭合并在下一段以相同语言重新打开
+func SplitMessage(content string, limit int) []string {
+	var chunks []string
+	fence := "" // 当前段开始时未闭合的代码块开头, 如 ```go
+	rest := content
+	for rest != "" {
+		prefix := ""
+		if fence != "" {
+			prefix = fence + "\n"
+		}
+		budget := limit - utf8.RuneCountInString(prefix)
+		if utf8.RuneCountInString(rest) <= budget {
+			chunks = append(chunks, prefix+rest)
+			break
+		}
+
+		// 预留闭合代码块的位置
+		cut := splitPoint(rest, budget-len("\n"+codeFence))
+		part := rest[:cut]
+		rest = rest[cut:]
+		fence = openFence(fence, part)
+
+		chunk := prefix + part
+		if fence != "" {
+			chunk = strings.TrimRight(chunk, "\n") + "\n" + codeFence
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// splitPoint 返回 s 前 budget 个字符内最合适的拆分位置(字节偏移)
+func splitPoint(s string, budget int) int {
+	budget = max(budget, 1)
+	window := s
+	if utf8.RuneCountInString(s) > budget {
+		window = s[:runeOffset(s, budget)]
+	}
+	spans := protectedPattern.FindAllStringIndex(s, -1)
+
+	// 拆分后的段落过短时退而求其次
+	minCut := len(window) / 3
+	for _, find := range []func(string) int{
+		func(w string) int { return lastAfter(w, []string{"\n\n"}) },
+		func(w string) int { return lastAfter(w, []string{"\n"}) },
+		func(w string) int { return lastAfter(w, sentenceEnds) },
+		func(w string) int { return lastAfter(w, []string{" ", "\t"}) },
+	} {
+		if cut := find(window); cut > minCut && !insideSpan(spans, cut) {
+			return cut
+		}
+	}
+
+	// 没有合适的边界, 在受保护内容之前硬拆
+	cut := len(window)
+	for _, span := range spans {
+		if span[0] < cut && cut < span[1] && span[0] > 0 {
+			return span[0]
+		}
+	}
+	return cut
+}
+
+// lastAfter 返回 w 中最后一个分隔符之后的位置, 不存在时返回 -1
+func lastAfter(w string, seps []string) int {
+	cut := -1
+	for _, sep := range seps {
+		if i := strings.LastIndex(w, sep); i >= 0 && i+len(sep) > cut {
+			cut = i + len(sep)
+		}
+	}
+	return cut
+}
+
+func insideSpan(spans [][]int, cut int) bool {
+	for _, span := range spans {
+		if span[0] < cut && cut < span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// runeOffset 第 n 个字符的字节偏移
+func runeOffset(s string, n int) int {
+	for i := range s {
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return len(s)
+}
+
+// openFence 扫描 part 中的代码块标记, 返回 part 结束时仍未闭合的代码块开头
+func openFence(fence, part string) string {
+	for _, line := range strings.Split(part, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, codeFence) {
+			continue
+		}
+		if fence == "" {
+			fence = line
+		} else {
+			fence = ""
+		}
+	}
+	return fence
+}
+
+// sendPromptAttachment 以 prompt.txt 附件发送prompt, 正文为说明并@bot
+func (b *DiscordBot) sendPromptAttachment(ctx context.Context, userAuth, botId, channelId, prompt string) (string, error) {
+	files := []*discordgo.File{newAttachmentFile("prompt.txt", "text/plain; charset=utf-8", []byte(prompt))}
+	return b.SendFileByAuthorization(ctx, userAuth, fmt.Sprintf("%s \n <@%s>", PromptAttachmentInstruction, botId), channelId, files)
+}
@@ -0,0 +1,79 @@
+package discord
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitMessageShort(t *testing.T) {
+	if chunks := SplitMessage("hello <@123>", 100); len(chunks) != 1 || chunks[0] != "hello <@123>" {
+		t.Fatalf("unexpected chunks: %q", chunks)
+	}
+}
+
+func TestSplitMessageBoundaries(t *testing.T) {
+	paragraph := strings.Repeat("word ", 15)
+	content := paragraph + "\n\n" + paragraph + "https://example.com/" + strings.Repeat("a", 30) + " end <@123456>"
+	chunks := SplitMessage(content, 100)
+	if len(chunks) < 2 {
+		t.Fatalf("expected several chunks, got %q", chunks)
+	}
+	for _, chunk := range chunks {
+		if n := utf8.RuneCountInString(chunk); n > 100 {
+			t.Fatalf("chunk exceeds limit (%d): %q", n, chunk)
+		}
+	}
+	if !strings.HasSuffix(chunks[0], "\n\n") {
+		t.Fatalf("first chunk should end at the paragraph break: %q", chunks[0])
+	}
+	joined := strings.Join(chunks, "")
+	if joined != content {
+		t.Fatal("chunks without code fences should join back to the original content")
+	}
+	for _, chunk := range chunks {
+		if strings.Contains(chunk, "https://") && !strings.Contains(chunk, "https://example.com/"+strings.Repeat("a", 30)) {
+			t.Fatalf("url was split: %q", chunk)
+		}
+	}
+	if !strings.HasSuffix(chunks[len(chunks)-1], "<@123456>") {
+		t.Fatalf("mention should stay intact in the last chunk: %q", chunks[len(chunks)-1])
+	}
+}
+
+func TestSplitMessageCodeFence(t *testing.T) {
+	var lines []string
+	for i := 0; i < 20; i++ {
+		lines = append(lines, "fmt.Println(\"line\")")
+	}
+	content := "intro\n```go\n" + strings.Join(lines, "\n") + "\n```\noutro"
+	chunks := SplitMessage(content, 120)
+	if len(chunks) < 3 {
+		t.Fatalf("expected the code block to span chunks, got %d", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if utf8.RuneCountInString(chunk) > 120 {
+			t.Fatalf("chunk %d exceeds limit: %q", i, chunk)
+		}
+		if strings.Count(chunk, "```")%2 != 0 {
+			t.Fatalf("chunk %d has an unbalanced code fence: %q", i, chunk)
+		}
+		if i > 0 && i < len(chunks)-1 && !strings.HasPrefix(chunk, "```go\n") {
+			t.Fatalf("chunk %d should reopen the go code fence: %q", i, chunk)
+		}
+	}
+}
+
+func TestUsePromptAttachment(t *testing.T) {
+	b := &DiscordBot{}
+	if b.usePromptAttachment(200000, 100) {
+		t.Fatal("attachment mode should be disabled by default")
+	}
+	WithPromptAttachment(PromptAttachmentConfig{MaxChunks: 10, MaxTokens: 8000})(b)
+	if b.usePromptAttachment(8000, 5) || !b.usePromptAttachment(8001, 5) {
+		t.Fatal("token threshold not applied")
+	}
+	if b.usePromptAttachment(100, 10) || !b.usePromptAttachment(100, 11) {
+		t.Fatal("chunk threshold not applied")
+	}
+}
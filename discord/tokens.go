@@ -0,0 +1,113 @@
+package discord
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/wwqdrh/gobot/types"
+	"github.com/wwqdrh/gokit/logger"
+	"go.uber.org/zap"
+)
+
+// DefaultEncoding gpt-4-turbo encoding
+const DefaultEncoding = "cl100k_base"
+
+// 与 OpenAI 计算 chat 消息 token 的方式一致: 每条消息额外 3 个, 设置 name 时额外 1 个, 回复起始额外 3 个
+const (
+	tokensPerMessage = 3
+	tokensPerName    = 1
+	tokensPerReply   = 3
+)
+
+var (
+	encodingMu sync.Mutex
+	encodings  = map[string]*tiktoken.Tiktoken{}
+)
+
+// Tokenizer 按需加载并缓存指定编码, 加载失败时返回错误, 下次调用会重试
+func Tokenizer(encoding string) (*tiktoken.Tiktoken, error) {
+	encodingMu.Lock()
+	defer encodingMu.Unlock()
+	if tke, ok := encodings[encoding]; ok {
+		return tke, nil
+	}
+	tke, err := tiktoken.GetEncoding(encoding)
+	if err != nil {
+		return nil, fmt.Errorf("load tiktoken encoding %s: %w", encoding, err)
+	}
+	encodings[encoding] = tke
+	return tke, nil
+}
+
+// EncodingForModel 返回模型对应的编码: gpt-4o 系列使用 o200k_base, 其余使用 cl100k_base
+func EncodingForModel(model string) string {
+	if strings.HasPrefix(model, "gpt-4o") {
+		return "o200k_base"
+	}
+	return DefaultEncoding
+}
+
+func CountTokens(text string) (int, error) {
+	return CountModelTokens("", text)
+}
+
+// CountModelTokens 使用模型对应的编码统计文本token数
+func CountModelTokens(model, text string) (int, error) {
+	tke, err := Tokenizer(EncodingForModel(model))
+	if err != nil {
+		return 0, err
+	}
+	return len(tke.Encode(text, nil, nil)), nil
+}
+
+// CountMessageTokens 按 OpenAI 的规则统计请求消息的 prompt token 数, 多模态内容仅统计文本部分
+func CountMessageTokens(model string, messages []types.OpenAIChatMessage) (int, error) {
+	total := tokensPerReply
+	for _, message := range messages {
+		total += tokensPerMessage
+		// role、content 与 name 分别编码, 拼接后编码会在边界处合并token
+		fields := []string{message.Role, messageText(message.Content)}
+		if message.Name != "" {
+			fields = append(fields, message.Name)
+			total += tokensPerName
+		}
+		for _, field := range fields {
+			n, err := CountModelTokens(model, field)
+			if err != nil {
+				return 0, err
+			}
+			total += n
+		}
+	}
+	return total, nil
+}
+
+// messageText 提取消息内容中的文本
+func messageText(content interface{}) string {
+	switch c := content.(type) {
+	case string:
+		return c
+	case []interface{}:
+		var sb strings.Builder
+		for _, part := range c {
+			if p, ok := part.(map[string]interface{}); ok && p["type"] == "text" {
+				if text, ok := p["text"].(string); ok {
+					sb.WriteString(text)
+				}
+			}
+		}
+		return sb.String()
+	}
+	return ""
+}
+
+// countTokensOrZero 用于统计usage, 编码不可用时记录日志并返回0
+func countTokensOrZero(model, text string) int {
+	tokens, err := CountModelTokens(model, text)
+	if err != nil {
+		logger.DefaultLogger.Warn("count tokens failed", zap.Error(err))
+	}
+	return tokens
+}
@@ -0,0 +1,29 @@
+package discord
+
+import (
+	"testing"
+
+	"github.com/wwqdrh/gobot/types"
+)
+
+func TestCountMessageTokensMatchesOpenAI(t *testing.T) {
+	if _, err := Tokenizer(DefaultEncoding); err != nil {
+		t.Skipf("encoding unavailable: %v", err)
+	}
+	// OpenAI cookbook "How to count tokens with tiktoken" 中的示例, gpt-4 的 prompt_tokens 为 129
+	messages := []types.OpenAIChatMessage{
+		{Role: "system", Content: "You are a helpful, pattern-following assistant that translates corporate jargon into plain English."},
+		{Role: "system", Name: "example_user", Content: "New synergies will help drive top-line growth."},
+		{Role: "system", Name: "example_assistant", Content: "Things working well together will increase revenue."},
+		{Role: "system", Name: "example_user", Content: "Let's circle back when we have more bandwidth to touch base on opportunities for increased leverage."},
+		{Role: "system", Name: "example_assistant", Content: "Let's talk later when we're less busy about how to do better."},
+		{Role: "user", Content: "This late pivot means we don't have time to boil the ocean for the client deliverable."},
+	}
+	got, err := CountMessageTokens("gpt-4", messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 129 {
+		t.Fatalf("CountMessageTokens = %d, want 129", got)
+	}
+}
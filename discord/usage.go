@@ -0,0 +1,436 @@
+package discord
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wwqdrh/gokit/logger"
+	"go.uber.org/zap"
+)
+
+const usageDateLayout = "2006-01-02"
+
+// UsageFlushInterval 用量账本写入存储文件的间隔
+var UsageFlushInterval = time.Minute
+
+// UsageRetentionDays 每日用量记录的保留天数, 更早的记录在写入存储文件时删除, 为0时不删除
+var UsageRetentionDays = 90
+
+var ErrQuotaNotFound = errors.New("usage quota not found")
+
+// Usage 用量
+type Usage struct {
+	Requests         int `json:"requests" swaggertype:"number" description:"请求数"`
+	PromptTokens     int `json:"promptTokens" swaggertype:"number" description:"prompt token数"`
+	CompletionTokens int `json:"completionTokens" swaggertype:"number" description:"completion token数"`
+	Images           int `json:"images" swaggertype:"number" description:"生成图片数"`
+}
+
+func (u *Usage) add(o Usage) {
+	u.Requests += o.Requests
+	u.PromptTokens += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
+	u.Images += o.Images
+}
+
+func (u *Usage) sub(o Usage) {
+	u.Requests -= o.Requests
+	u.PromptTokens -= o.PromptTokens
+	u.CompletionTokens -= o.CompletionTokens
+	u.Images -= o.Images
+}
+
+func (u Usage) TotalTokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// UsageRecord 某个 proxySecret 在某天(或某月)的累计用量
+type UsageRecord struct {
+	SecretId string `json:"secretId" swaggertype:"string" description:"proxySecret ID(摘要)"`
+	Masked   string `json:"masked" swaggertype:"string" description:"脱敏后的proxySecret"`
+	Date     string `json:"date" swaggertype:"string" description:"日期 2006-01-02, 月度汇总时为 2006-01"`
+	Usage
+}
+
+// UsageQuota proxySecret 的用量配额, 为0的项不限制
+type UsageQuota struct {
+	SecretId      string `json:"secretId" swaggertype:"string" description:"proxySecret ID(摘要), 由服务端根据 secret 生成"`
+	Secret        string `json:"secret,omitempty" swaggertype:"string" description:"proxySecret, 仅用于设置配额, 不会返回与持久化"`
+	Masked        string `json:"masked" swaggertype:"string" description:"脱敏后的proxySecret"`
+	DailyRequests int    `json:"dailyRequests" swaggertype:"number" description:"每日请求数上限"`
+	DailyTokens   int    `json:"dailyTokens" swaggertype:"number" description:"每日token上限"`
+	DailyImages   int    `json:"dailyImages" swaggertype:"number" description:"每日生成图片上限"`
+	MonthlyTokens int    `json:"monthlyTokens" swaggertype:"number" description:"每月token上限"`
+}
+
+// QuotaExceededError proxySecret 已超出配额
+type QuotaExceededError struct {
+	SecretId string
+	Limit    string
+	Used     int
+	Max      int
+	ErrCode  int
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("usage quota exceeded: %s %d/%d", e.Limit, e.Used, e.Max)
+}
+
+type usageKey struct {
+	secretId string
+	date     string
+}
+
+// usageStore 持久化格式
+type usageStore struct {
+	Records []UsageRecord `json:"records"`
+	Quotas  []UsageQuota  `json:"quotas"`
+}
+
+// usageLedger 按 proxySecret 按天记录用量并校验配额, 定期持久化到json文件
+type usageLedger struct {
+	mu        sync.Mutex
+	records   map[usageKey]*UsageRecord
+	quotas    map[string]UsageQuota
+	reserved  map[string]Usage // secretId -> 进行中请求预留的用量
+	storePath string
+	dirty     bool
+}
+
+// usageReservation 请求开始前预留的配额, 成功后以实际用量 commit, 失败时 cancel 归还
+type usageReservation struct {
+	ledger *usageLedger
+	secret string
+	usage  Usage
+	done   bool
+}
+
+func newUsageLedger() *usageLedger {
+	return &usageLedger{
+		records:  map[usageKey]*UsageRecord{},
+		quotas:   map[string]UsageQuota{},
+		reserved: map[string]Usage{},
+	}
+}
+
+// WithUsageStorePath 用量账本及配额的持久化文件
+func WithUsageStorePath(path string) WithConfig {
+	return func(db *DiscordBot) {
+		db.usageStorePath = path
+	}
+}
+
+// WithUsageQuotas 初始配额, 持久化文件中存在同一 proxySecret 的配额时以文件为准
+func WithUsageQuotas(quotas ...UsageQuota) WithConfig {
+	return func(db *DiscordBot) {
+		for _, quota := range quotas {
+			db.usage.setQuota(quota) // 尚未设置存储文件, 不会失败
+		}
+	}
+}
+
+// load 从存储文件加载用量与配额
+func (l *usageLedger) load(path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.storePath = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l.saveLocked()
+	}
+	if err != nil {
+		return err
+	}
+	var stored usageStore
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("parse usage store %s: %w", path, err)
+	}
+	for _, record := range stored.Records {
+		record := record
+		l.records[usageKey{record.SecretId, record.Date}] = &record
+	}
+	for _, quota := range stored.Quotas {
+		l.quotas[quota.SecretId] = quota
+	}
+	l.pruneLocked(time.Now())
+	return nil
+}
+
+func (l *usageLedger) saveLocked() error {
+	if l.storePath == "" {
+		return nil
+	}
+	stored := usageStore{Records: l.sortedLocked(), Quotas: l.quotaListLocked()}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := l.storePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, l.storePath); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
+
+// flush 删除过期的记录, 有未保存的用量时写入存储文件
+func (l *usageLedger) flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pruneLocked(time.Now())
+	if !l.dirty {
+		return nil
+	}
+	return l.saveLocked()
+}
+
+// pruneLocked 删除超过 UsageRetentionDays 的每日记录
+func (l *usageLedger) pruneLocked(now time.Time) {
+	if UsageRetentionDays <= 0 {
+		return
+	}
+	oldest := now.AddDate(0, 0, -UsageRetentionDays).Format(usageDateLayout)
+	for key := range l.records {
+		if key.date < oldest {
+			delete(l.records, key)
+			l.dirty = true
+		}
+	}
+}
+
+// record 累加 secret 当天的用量
+func (l *usageLedger) record(secret string, u Usage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recordLocked(secret, u)
+}
+
+func (l *usageLedger) recordLocked(secret string, u Usage) {
+	id := AuthId(secret)
+	key := usageKey{id, time.Now().Format(usageDateLayout)}
+	record, ok := l.records[key]
+	if !ok {
+		record = &UsageRecord{SecretId: id, Masked: MaskSecret(secret), Date: key.date}
+		l.records[key] = record
+	}
+	record.add(u)
+	l.dirty = true
+}
+
+// check 校验 secret 是否已超出配额, 进行中请求预留的用量一并计入, 超出时返回 *QuotaExceededError
+func (l *usageLedger) check(secret string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.checkLocked(AuthId(secret), Usage{})
+}
+
+// reserve 校验配额并为请求预留 u, 避免并发请求同时通过校验后一起超出配额
+func (l *usageLedger) reserve(secret string, u Usage) (*usageReservation, error) {
+	id := AuthId(secret)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.checkLocked(id, u); err != nil {
+		return nil, err
+	}
+	reserved := l.reserved[id]
+	reserved.add(u)
+	l.reserved[id] = reserved
+	return &usageReservation{ledger: l, secret: secret, usage: u}, nil
+}
+
+// commit 以实际用量替换预留的用量
+func (r *usageReservation) commit(u Usage) {
+	l := r.ledger
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r.releaseLocked() {
+		l.recordLocked(r.secret, u)
+	}
+}
+
+// cancel 归还预留的用量, 已 commit 时不做任何事
+func (r *usageReservation) cancel() {
+	l := r.ledger
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r.releaseLocked()
+}
+
+func (r *usageReservation) releaseLocked() bool {
+	if r.done {
+		return false
+	}
+	r.done = true
+	id := AuthId(r.secret)
+	reserved := r.ledger.reserved[id]
+	reserved.sub(r.usage)
+	if reserved == (Usage{}) {
+		delete(r.ledger.reserved, id)
+	} else {
+		r.ledger.reserved[id] = reserved
+	}
+	return true
+}
+
+// checkLocked 已用量与预留量之和达到上限, 或不足以容纳 extra 时返回 *QuotaExceededError
+func (l *usageLedger) checkLocked(id string, extra Usage) error {
+	quota, ok := l.quotas[id]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	reserved := l.reserved[id]
+	daily := reserved
+	if record, ok := l.records[usageKey{id, now.Format(usageDateLayout)}]; ok {
+		daily.add(record.Usage)
+	}
+	monthly := reserved.TotalTokens()
+	month := now.Format("2006-01")
+	for key, record := range l.records {
+		if key.secretId == id && strings.HasPrefix(key.date, month) {
+			monthly += record.TotalTokens()
+		}
+	}
+
+	limits := []struct {
+		name             string
+		used, extra, max int
+	}{
+		{"dailyRequests", daily.Requests, extra.Requests, quota.DailyRequests},
+		{"dailyTokens", daily.TotalTokens(), extra.TotalTokens(), quota.DailyTokens},
+		{"dailyImages", daily.Images, extra.Images, quota.DailyImages},
+		{"monthlyTokens", monthly, extra.TotalTokens(), quota.MonthlyTokens},
+	}
+	for _, limit := range limits {
+		if limit.max > 0 && (limit.used >= limit.max || limit.used+limit.extra > limit.max) {
+			return &QuotaExceededError{
+				SecretId: id,
+				Limit:    limit.name,
+				Used:     limit.used,
+				Max:      limit.max,
+				ErrCode:  http.StatusTooManyRequests,
+			}
+		}
+	}
+	return nil
+}
+
+func (l *usageLedger) sortedLocked() []UsageRecord {
+	records := make([]UsageRecord, 0, len(l.records))
+	for _, record := range l.records {
+		records = append(records, *record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Date != records[j].Date {
+			return records[i].Date < records[j].Date
+		}
+		return records[i].SecretId < records[j].SecretId
+	})
+	return records
+}
+
+// report 返回 [from, to] 日期范围内的每日用量, from/to 为空时不限制, secretId 为空时返回全部
+func (l *usageLedger) report(from, to, secretId string) []UsageRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var records []UsageRecord
+	for _, record := range l.sortedLocked() {
+		if (from != "" && record.Date < from) || (to != "" && record.Date > to) {
+			continue
+		}
+		if secretId != "" && record.SecretId != secretId {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// monthly 返回指定月份(2006-01)每个 proxySecret 的累计用量
+func (l *usageLedger) monthly(month string) []UsageRecord {
+	var records []UsageRecord
+	index := map[string]int{}
+	for _, record := range l.report(month+"-01", month+"-31", "") {
+		i, ok := index[record.SecretId]
+		if !ok {
+			i = len(records)
+			index[record.SecretId] = i
+			records = append(records, UsageRecord{SecretId: record.SecretId, Masked: record.Masked, Date: month})
+		}
+		records[i].add(record.Usage)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].SecretId < records[j].SecretId })
+	return records
+}
+
+func (l *usageLedger) quotaListLocked() []UsageQuota {
+	quotas := make([]UsageQuota, 0, len(l.quotas))
+	for _, quota := range l.quotas {
+		quotas = append(quotas, quota)
+	}
+	sort.Slice(quotas, func(i, j int) bool { return quotas[i].SecretId < quotas[j].SecretId })
+	return quotas
+}
+
+func (l *usageLedger) listQuotas() []UsageQuota {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.quotaListLocked()
+}
+
+// setQuota 设置 quota.Secret 对应的配额, 不保存原始 secret
+func (l *usageLedger) setQuota(quota UsageQuota) (UsageQuota, error) {
+	quota.SecretId = AuthId(quota.Secret)
+	quota.Masked = MaskSecret(quota.Secret)
+	quota.Secret = ""
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.quotas[quota.SecretId] = quota
+	return quota, l.saveLocked()
+}
+
+func (l *usageLedger) deleteQuota(secretId string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.quotas[secretId]; !ok {
+		return ErrQuotaNotFound
+	}
+	delete(l.quotas, secretId)
+	return l.saveLocked()
+}
+
+// CheckQuota 校验 proxySecret 是否已超出配额, 超出时返回 *QuotaExceededError
+func (b *DiscordBot) CheckQuota(secret string) error {
+	return b.usage.check(secret)
+}
+
+// RecordUsage 记录 proxySecret 的用量, 供未经过 ChatCompletion 的请求(如图片生成)计费
+func (b *DiscordBot) RecordUsage(secret string, u Usage) {
+	b.usage.record(secret, u)
+}
+
+// UsageReport 返回 [from, to] 日期范围内每个 proxySecret 的每日用量
+func (b *DiscordBot) UsageReport(from, to string) []UsageRecord {
+	return b.usage.report(from, to, "")
+}
+
+// usageFlushTask 定期持久化用量账本
+func (b *DiscordBot) usageFlushTask() {
+	for b.life.sleep(UsageFlushInterval) {
+		if err := b.usage.flush(); err != nil {
+			logger.DefaultLogger.Warn("flush usage ledger failed", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package discord
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUsageLedgerQuota(t *testing.T) {
+	l := newUsageLedger()
+	if _, err := l.setQuota(UsageQuota{Secret: "team-a-secret", DailyRequests: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := l.check("team-a-secret"); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		l.record("team-a-secret", Usage{Requests: 1, PromptTokens: 10, CompletionTokens: 5})
+	}
+	var quotaErr *QuotaExceededError
+	if err := l.check("team-a-secret"); !errors.As(err, &quotaErr) || quotaErr.Limit != "dailyRequests" {
+		t.Fatalf("expected dailyRequests quota error, got %v", err)
+	}
+	if err := l.check("team-b-secret"); err != nil {
+		t.Fatalf("secret without quota should not be limited: %v", err)
+	}
+}
+
+func TestUsageLedgerMonthlyAndPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	l := newUsageLedger()
+	if err := l.load(path); err != nil {
+		t.Fatal(err)
+	}
+	l.record("team-a-secret", Usage{Requests: 1, PromptTokens: 10, CompletionTokens: 5, Images: 1})
+	l.record("team-a-secret", Usage{Requests: 1, PromptTokens: 20, CompletionTokens: 5})
+	if err := l.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := newUsageLedger()
+	if err := reloaded.load(path); err != nil {
+		t.Fatal(err)
+	}
+	records := reloaded.monthly(time.Now().Format("2006-01"))
+	if len(records) != 1 {
+		t.Fatalf("expected 1 monthly record, got %d", len(records))
+	}
+	got := records[0]
+	if got.SecretId != AuthId("team-a-secret") || got.Requests != 2 || got.TotalTokens() != 40 || got.Images != 1 {
+		t.Fatalf("unexpected monthly record: %+v", got)
+	}
+}
+
+func TestUsageLedgerReservation(t *testing.T) {
+	l := newUsageLedger()
+	if _, err := l.setQuota(UsageQuota{Secret: "team-a-secret", DailyRequests: 2, DailyTokens: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 进行中的请求占用配额, 并发请求不能同时通过校验
+	first, err := l.reserve("team-a-secret", Usage{Requests: 1, PromptTokens: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var quotaErr *QuotaExceededError
+	if _, err := l.reserve("team-a-secret", Usage{Requests: 1, PromptTokens: 70}); !errors.As(err, &quotaErr) || quotaErr.Limit != "dailyTokens" {
+		t.Fatalf("expected dailyTokens quota error, got %v", err)
+	}
+	second, err := l.reserve("team-a-secret", Usage{Requests: 1, PromptTokens: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.check("team-a-secret"); !errors.As(err, &quotaErr) || quotaErr.Limit != "dailyRequests" {
+		t.Fatalf("expected dailyRequests quota error, got %v", err)
+	}
+
+	// 失败的请求归还配额, 成功的请求按实际用量计入
+	second.cancel()
+	first.commit(Usage{Requests: 1, PromptTokens: 40, CompletionTokens: 20})
+	first.cancel()
+	if err := l.check("team-a-secret"); err != nil {
+		t.Fatalf("released reservation still counted: %v", err)
+	}
+	if len(l.reserved) != 0 {
+		t.Fatalf("reservations not released: %+v", l.reserved)
+	}
+	if got := l.report("", "", AuthId("team-a-secret")); len(got) != 1 || got[0].Requests != 1 || got[0].TotalTokens() != 60 {
+		t.Fatalf("unexpected records: %+v", got)
+	}
+}
+
+func TestUsageLedgerPrunesExpiredRecords(t *testing.T) {
+	l := newUsageLedger()
+	id := AuthId("team-a-secret")
+	old := time.Now().AddDate(0, 0, -UsageRetentionDays-1).Format(usageDateLayout)
+	l.records[usageKey{id, old}] = &UsageRecord{SecretId: id, Date: old, Usage: Usage{Requests: 1}}
+	l.record("team-a-secret", Usage{Requests: 1})
+
+	if err := l.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := l.report("", "", ""); len(got) != 1 || got[0].Date == old {
+		t.Fatalf("expired record not pruned: %+v", got)
+	}
+}
@@ -10,7 +10,7 @@ import (
 	"github.com/wwqdrh/gobot/types"
 )
 
-func res2OpenAI(m *discordgo.MessageCreate) types.OpenAIChatCompletionResponse {
+func res2OpenAI(m *discordgo.MessageCreate, pending *pendingReply) types.OpenAIChatCompletionResponse {
 	if len(m.Embeds) != 0 {
 		for _, embed := range m.Embeds {
 			if embed.Image != nil && !strings.Contains(m.Content, embed.Image.URL) {
@@ -22,14 +22,18 @@ func res2OpenAI(m *discordgo.MessageCreate) types.OpenAIChatCompletionResponse {
 		}
 	}
 
-	promptTokens := CountTokens(m.ReferencedMessage.Content)
-	completionTokens := CountTokens(m.Content)
+	model := pending.model
+	if model == "" {
+		model = "Coze-Model"
+	}
+	promptTokens := pending.promptTokens
+	completionTokens := countTokensOrZero(pending.tokenModel, m.Content)
 
 	return types.OpenAIChatCompletionResponse{
 		ID:      m.ID,
 		Object:  "chat.completion",
 		Created: time.Now().Unix(),
-		Model:   "Coze-Model",
+		Model:   model,
 		Choices: []types.OpenAIChoice{
 			{
 				Index: 0,
@@ -62,7 +66,7 @@ func processMessageUpdate(m *discordgo.MessageUpdate) ReplyResp {
 	}
 }
 
-func processMessageUpdateForOpenAI(m *discordgo.MessageUpdate) types.OpenAIChatCompletionResponse {
+func processMessageUpdateForOpenAI(m *discordgo.MessageUpdate, pending *pendingReply) types.OpenAIChatCompletionResponse {
 	if len(m.Embeds) != 0 {
 		for _, embed := range m.Embeds {
 			if embed.Image != nil && !strings.Contains(m.Content, embed.Image.URL) {
@@ -74,14 +78,18 @@ func processMessageUpdateForOpenAI(m *discordgo.MessageUpdate) types.OpenAIChatC
 		}
 	}
 
-	promptTokens := CountTokens(m.ReferencedMessage.Content)
-	completionTokens := CountTokens(m.Content)
+	model := pending.model
+	if model == "" {
+		model = "Coze-Model"
+	}
+	promptTokens := pending.promptTokens
+	completionTokens := countTokensOrZero(pending.tokenModel, m.Content)
 
 	return types.OpenAIChatCompletionResponse{
 		ID:      m.ID,
 		Object:  "chat.completion",
 		Created: time.Now().Unix(),
-		Model:   "Coze-Model",
+		Model:   model,
 		Choices: []types.OpenAIChoice{
 			{
 				Index: 0,
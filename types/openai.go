@@ -14,6 +14,7 @@ type OpenAIChatCompletionExtraRequest struct {
 type OpenAIChatMessage struct {
 	Role    string      `json:"role"`
 	Content interface{} `json:"content"`
+	Name    string      `json:"name,omitempty"`
 }
 
 type OpenAIErrorResponse struct {
@@ -36,6 +37,7 @@ type OpenAIChatCompletionResponse struct {
 	Usage             OpenAIUsage    `json:"usage"`
 	SystemFingerprint *string        `json:"system_fingerprint"`
 	Suggestions       []string       `json:"suggestions"`
+	Cached            bool           `json:"cached,omitempty"`
 }
 
 type OpenAIChoice struct {